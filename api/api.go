@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"slices"
+	"time"
+)
+
+// SnapshotSource provides the snapshot groups of the last successful refresh of a repository.
+type SnapshotSource interface {
+	GroupData() ([]snapshot.GroupData, time.Time, bool)
+}
+
+// StatisticSource provides the statistics of the last successful refresh of a repository.
+type StatisticSource interface {
+	Statistics() (statistic.RawDataMetrics, time.Time, bool)
+}
+
+// Repository is a repository served by the API.
+type Repository struct {
+	Name       string
+	Snapshots  SnapshotSource
+	Statistics StatisticSource
+}
+
+type repositoryResponse struct {
+	Name                  string                    `json:"name"`
+	SnapshotsRefreshedAt  *time.Time                `json:"snapshots_refreshed_at"`
+	GroupCount            int                       `json:"group_count"`
+	SnapshotCount         int                       `json:"snapshot_count"`
+	Statistics            *statistic.RawDataMetrics `json:"statistics"`
+	StatisticsRefreshedAt *time.Time                `json:"statistics_refreshed_at"`
+}
+
+type groupResponse struct {
+	GroupKey      snapshot.GroupKey `json:"group_key"`
+	SnapshotCount int               `json:"snapshot_count"`
+	// SnapshotsTruncated is set if the snapshots endpoint lists only the most recent snapshots of the group.
+	SnapshotsTruncated bool               `json:"snapshots_truncated"`
+	LastSnapshot       *snapshot.Snapshot `json:"last_snapshot"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	repositories []Repository
+}
+
+// NewHandler returns a read-only JSON API serving the cached snapshot and statistic data of repositories:
+//
+//	GET /api/v1/repositories
+//	GET /api/v1/repositories/{name}/groups
+//	GET /api/v1/repositories/{name}/snapshots?hostname=...&tag=...
+func NewHandler(repositories []Repository) http.Handler {
+	h := &handler{repositories: repositories}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/repositories", h.listRepositories)
+	mux.HandleFunc("GET /api/v1/repositories/{name}/groups", h.listGroups)
+	mux.HandleFunc("GET /api/v1/repositories/{name}/snapshots", h.listSnapshots)
+	return mux
+}
+
+func (h *handler) listRepositories(w http.ResponseWriter, r *http.Request) {
+	response := make([]repositoryResponse, 0, len(h.repositories))
+	for _, repository := range h.repositories {
+		entry := repositoryResponse{Name: repository.Name}
+
+		if groupData, refreshedAt, ok := repository.Snapshots.GroupData(); ok {
+			entry.SnapshotsRefreshedAt = &refreshedAt
+			entry.GroupCount = len(groupData)
+			for _, group := range groupData {
+				entry.SnapshotCount += group.SnapshotCount()
+			}
+		}
+
+		if repository.Statistics != nil {
+			if statistics, refreshedAt, ok := repository.Statistics.Statistics(); ok {
+				entry.Statistics = &statistics
+				entry.StatisticsRefreshedAt = &refreshedAt
+			}
+		}
+
+		response = append(response, entry)
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (h *handler) listGroups(w http.ResponseWriter, r *http.Request) {
+	groupData, ok := h.groupData(w, r)
+	if !ok {
+		return
+	}
+
+	response := make([]groupResponse, 0, len(groupData))
+	for _, group := range groupData {
+		entry := groupResponse{
+			GroupKey:           group.GroupKey,
+			SnapshotCount:      group.SnapshotCount(),
+			SnapshotsTruncated: len(group.Snapshots) < group.SnapshotCount(),
+		}
+		if len(group.Snapshots) > 0 {
+			last := group.Snapshots[0]
+			for _, s := range group.Snapshots {
+				if s.Time.After(last.Time) {
+					last = s
+				}
+			}
+			entry.LastSnapshot = &last
+		}
+		response = append(response, entry)
+	}
+
+	writeJson(w, http.StatusOK, response)
+}
+
+func (h *handler) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	groupData, ok := h.groupData(w, r)
+	if !ok {
+		return
+	}
+
+	hostname := r.URL.Query().Get("hostname")
+	tags := r.URL.Query()["tag"]
+
+	response := []snapshot.Snapshot{}
+	for _, group := range groupData {
+		for _, s := range group.Snapshots {
+			if hostname != "" && s.Hostname != hostname {
+				continue
+			}
+			if !containsAll(s.Tags, tags) {
+				continue
+			}
+			response = append(response, s)
+		}
+	}
+
+	slices.SortStableFunc(response, func(a, b snapshot.Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	writeJson(w, http.StatusOK, response)
+}
+
+// groupData returns the cached snapshot groups of the repository named in the request path.
+// If the repository is unknown or wasn't refreshed yet, an error response is written and ok is false.
+func (h *handler) groupData(w http.ResponseWriter, r *http.Request) ([]snapshot.GroupData, bool) {
+	name := r.PathValue("name")
+	for _, repository := range h.repositories {
+		if repository.Name != name {
+			continue
+		}
+
+		groupData, _, ok := repository.Snapshots.GroupData()
+		if !ok {
+			writeJson(w, http.StatusServiceUnavailable, errorResponse{Error: "repository was not refreshed successfully yet"})
+			return nil, false
+		}
+		return groupData, true
+	}
+
+	writeJson(w, http.StatusNotFound, errorResponse{Error: "repository not found"})
+	return nil, false
+}
+
+func containsAll(values []string, required []string) bool {
+	for _, r := range required {
+		if !slices.Contains(values, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write API response", "error", err)
+	}
+}
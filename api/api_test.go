@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"testing"
+	"time"
+)
+
+type fakeSnapshotSource struct {
+	groupData   []snapshot.GroupData
+	refreshedAt time.Time
+	ok          bool
+}
+
+func (f fakeSnapshotSource) GroupData() ([]snapshot.GroupData, time.Time, bool) {
+	return f.groupData, f.refreshedAt, f.ok
+}
+
+type fakeStatisticSource struct {
+	metrics     statistic.RawDataMetrics
+	refreshedAt time.Time
+	ok          bool
+}
+
+func (f fakeStatisticSource) Statistics() (statistic.RawDataMetrics, time.Time, bool) {
+	return f.metrics, f.refreshedAt, f.ok
+}
+
+var (
+	refreshedAt = time.Date(2025, 10, 12, 6, 0, 0, 0, time.UTC)
+	first       = snapshot.Snapshot{
+		ID:       "c9719c8941be0587617fe31977d8b8809c1897c000225150e37126f741ef5b8c",
+		ShortID:  "c9719c89",
+		Time:     time.Date(2025, 10, 8, 5, 23, 10, 0, time.UTC),
+		Paths:    []string{"/root/kuma"},
+		Hostname: "SK12",
+		Tags:     []string{"kuma"},
+	}
+	second = snapshot.Snapshot{
+		ID:       "4e66c0cc6b3911b64dbf7fba48d9283dcc2d9bb198cdc608c8c25cc6b0c08046",
+		ShortID:  "4e66c0cc",
+		Time:     time.Date(2025, 10, 12, 5, 23, 9, 0, time.UTC),
+		Paths:    []string{"/root/kuma"},
+		Hostname: "SK12",
+		Tags:     []string{"kuma"},
+	}
+	other = snapshot.Snapshot{
+		ID:       "96abef0e00a18ab45ace9f04dabdd5e5e6585cf8a1aac753ec9af3c756e5ac42",
+		ShortID:  "96abef0e",
+		Time:     time.Date(2025, 10, 7, 17, 56, 1, 0, time.UTC),
+		Paths:    []string{"/"},
+		Hostname: "DPC1",
+		Tags:     []string{"full-server", "daily"},
+	}
+)
+
+func newTestHandler() http.Handler {
+	return NewHandler([]Repository{
+		{
+			Name: "local",
+			Snapshots: fakeSnapshotSource{
+				groupData: []snapshot.GroupData{
+					{GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}}, Snapshots: []snapshot.Snapshot{second, first}},
+					{GroupKey: snapshot.GroupKey{Hostname: "DPC1", Tags: []string{"full-server", "daily"}}, Snapshots: []snapshot.Snapshot{other}},
+				},
+				refreshedAt: refreshedAt,
+				ok:          true,
+			},
+			Statistics: fakeStatisticSource{
+				metrics:     statistic.RawDataMetrics{TotalSize: 181885552, SnapshotCount: 3},
+				refreshedAt: refreshedAt,
+				ok:          true,
+			},
+		},
+		{
+			Name:       "offsite",
+			Snapshots:  fakeSnapshotSource{},
+			Statistics: fakeStatisticSource{},
+		},
+	})
+}
+
+func get(t *testing.T, target string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", got)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid json: %v: %s", err, rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestListRepositories(t *testing.T) {
+	var got []repositoryResponse
+	if code := get(t, "/api/v1/repositories", &got); code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+
+	want := []repositoryResponse{
+		{
+			Name:                  "local",
+			SnapshotsRefreshedAt:  &refreshedAt,
+			GroupCount:            2,
+			SnapshotCount:         3,
+			Statistics:            &statistic.RawDataMetrics{TotalSize: 181885552, SnapshotCount: 3},
+			StatisticsRefreshedAt: &refreshedAt,
+		},
+		{
+			Name: "offsite",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %+v, want %+v", got, want)
+	}
+}
+
+func TestListGroups(t *testing.T) {
+	var got []groupResponse
+	if code := get(t, "/api/v1/repositories/local/groups", &got); code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+	}
+
+	want := []groupResponse{
+		{GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}}, SnapshotCount: 2, LastSnapshot: &second},
+		{GroupKey: snapshot.GroupKey{Hostname: "DPC1", Tags: []string{"full-server", "daily"}}, SnapshotCount: 1, LastSnapshot: &other},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got = %+v, want %+v", got, want)
+	}
+}
+
+func TestListGroups_Truncated(t *testing.T) {
+	h := NewHandler([]Repository{{
+		Name: "local",
+		Snapshots: fakeSnapshotSource{
+			groupData: []snapshot.GroupData{
+				{GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}}, Snapshots: []snapshot.Snapshot{second, first}, Count: 250},
+			},
+			ok: true,
+		},
+	}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/repositories/local/groups", nil))
+
+	var got []groupResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json: %v: %s", err, rec.Body.String())
+	}
+	if len(got) != 1 || got[0].SnapshotCount != 250 || !got[0].SnapshotsTruncated {
+		t.Errorf("got = %+v, want 250 snapshots with snapshots_truncated set", got)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   []snapshot.Snapshot
+	}{
+		{
+			name:   "all snapshots sorted by time",
+			target: "/api/v1/repositories/local/snapshots",
+			want:   []snapshot.Snapshot{other, first, second},
+		},
+		{
+			name:   "by hostname",
+			target: "/api/v1/repositories/local/snapshots?hostname=SK12",
+			want:   []snapshot.Snapshot{first, second},
+		},
+		{
+			name:   "by tags",
+			target: "/api/v1/repositories/local/snapshots?tag=daily&tag=full-server",
+			want:   []snapshot.Snapshot{other},
+		},
+		{
+			name:   "no match",
+			target: "/api/v1/repositories/local/snapshots?hostname=SK12&tag=daily",
+			want:   []snapshot.Snapshot{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []snapshot.Snapshot
+			if code := get(t, tt.target, &got); code != http.StatusOK {
+				t.Fatalf("status code = %d, want %d", code, http.StatusOK)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+	}{
+		{name: "unknown repository", target: "/api/v1/repositories/missing/groups", wantCode: http.StatusNotFound},
+		{name: "not refreshed yet", target: "/api/v1/repositories/offsite/snapshots", wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got errorResponse
+			if code := get(t, tt.target, &got); code != tt.wantCode {
+				t.Errorf("status code = %d, want %d", code, tt.wantCode)
+			}
+			if got.Error == "" {
+				t.Errorf("missing error message")
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/repositories/local/snapshots", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,117 @@
+// Package check runs restic check and exports whether it found errors in the repository.
+// restic check holds an exclusive lock of the repository while it runs, so backups started meanwhile fail.
+package check
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"restic-stats-exporter/util"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Collector struct {
+	resticExecutablePath string
+	commandExecutor      util.CommandExecutor
+	parseErrors          prometheus.Counter
+	cache                util.Cache[result]
+}
+
+// result holds the outcome of a single restic check invocation.
+type result struct {
+	exitCode  int
+	numErrors int
+}
+
+// summary is the last message restic check prints with --json, also if it found errors.
+type summary struct {
+	MessageType string `json:"message_type"`
+	NumErrors   int    `json:"num_errors"`
+}
+
+func NewCheckCollector(resticExecutablePath string, commandExecutor util.CommandExecutor, parseErrors prometheus.Counter) *Collector {
+	return &Collector{
+		resticExecutablePath: resticExecutablePath,
+		commandExecutor:      commandExecutor,
+		parseErrors:          parseErrors,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- errorsDesc
+	ch <- checkTimeDesc
+	ch <- checkExitCode
+}
+
+// Run checks the repository every interval until ctx is cancelled, Collect serves the last result meanwhile.
+// The first check runs one interval after the last finished check or, if there is none, after Run was called,
+// so restarting the exporter doesn't lock the repository.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	first := time.Now().Add(interval)
+	if res, ok := c.cache.LastSuccess(); ok {
+		first = res.RefreshedAt.Add(interval)
+	}
+	c.cache.RunFrom(ctx, first, interval, c.refresh, nil)
+}
+
+// refresh checks the repository with restic. It is successful if the check finished, even if it found errors.
+func (c *Collector) refresh(ctx context.Context) (result, bool) {
+	out, err, exitCode := c.commandExecutor(ctx, c.resticExecutablePath, "check", "--json")
+
+	s, found := readSummary(out)
+	if !found {
+		if err == nil {
+			if c.parseErrors != nil {
+				c.parseErrors.Inc()
+			}
+			exitCode = util.JSONParseErrorExitCode
+		}
+		return result{exitCode: exitCode}, false
+	}
+	return result{exitCode: exitCode, numErrors: s.NumErrors}, true
+}
+
+// readSummary returns the summary message of the output of restic check.
+func readSummary(out []byte) (s summary, found bool) {
+	for line := range bytes.Lines(out) {
+		var message summary
+		if json.Unmarshal(line, &message) == nil && message.MessageType == "summary" {
+			s, found = message, true
+		}
+	}
+	return s, found
+}
+
+// LastCheck returns the number of errors found by the last finished check and when it started, ok is false before the first check finished.
+func (c *Collector) LastCheck() (numErrors int, checkedAt time.Time, ok bool) {
+	res, ok := c.cache.LastSuccess()
+	return res.Value.numErrors, res.RefreshedAt, ok
+}
+
+// Restore seeds the collector with the result of a check that finished before, so Run schedules the next check from checkedAt.
+func (c *Collector) Restore(numErrors int, checkedAt time.Time) {
+	// restic check exits with 1 if it found errors
+	exitCode := 0
+	if numErrors > 0 {
+		exitCode = 1
+	}
+	c.cache.Restore(result{exitCode: exitCode, numErrors: numErrors}, checkedAt)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	res, ok := c.cache.Current(context.Background(), c.refresh)
+	if !ok {
+		// the first check is still running
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(checkExitCode, prometheus.GaugeValue, float64(res.Value.exitCode))
+	if !res.OK {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.GaugeValue, float64(res.Value.numErrors))
+	ch <- prometheus.MustNewConstMetric(checkTimeDesc, prometheus.GaugeValue, float64(res.RefreshedAt.Unix()))
+}
@@ -0,0 +1,22 @@
+package check
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	errorsDesc = prometheus.NewDesc(
+		"restic_check_errors",
+		"Number of errors found by the last finished check",
+		nil, nil,
+	)
+
+	checkTimeDesc = prometheus.NewDesc(
+		"restic_check_time_seconds",
+		"Unix timestamp: start of the last finished check",
+		nil, nil,
+	)
+
+	checkExitCode = prometheus.NewDesc("restic_check_exit_code",
+		"Exit code of the last check command. See restic exit codes, except 1684 for json output parsing errors: "+
+			"https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes",
+		nil, nil)
+)
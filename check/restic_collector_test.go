@@ -0,0 +1,199 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		exitCode int
+		want     string
+	}{
+		{
+			name:     "no errors",
+			output:   `{"message_type":"summary","num_errors":0,"broken_packs":null,"suggest_repair_index":false,"suggest_prune":false}` + "\n",
+			exitCode: 0,
+			want: `
+# HELP restic_check_errors Number of errors found by the last finished check
+# TYPE restic_check_errors gauge
+restic_check_errors 0
+# HELP restic_check_exit_code Exit code of the last check command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_check_exit_code gauge
+restic_check_exit_code 0
+`,
+		},
+		{
+			name:     "errors found",
+			output:   `{"message_type":"summary","num_errors":3,"broken_packs":["c9e08299b1f37c24bb65380ad7a6facb5b4e2b26cc9e4dd8d5cf772f24dfe6fc"],"suggest_repair_index":false,"suggest_prune":false}` + "\n",
+			err:      errors.New("exit status 1"),
+			exitCode: 1,
+			want: `
+# HELP restic_check_errors Number of errors found by the last finished check
+# TYPE restic_check_errors gauge
+restic_check_errors 3
+# HELP restic_check_exit_code Exit code of the last check command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_check_exit_code gauge
+restic_check_exit_code 1
+`,
+		},
+		{
+			name:     "check not finished",
+			err:      errors.New("exit status 12"),
+			exitCode: 12,
+			want: `
+# HELP restic_check_exit_code Exit code of the last check command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_check_exit_code gauge
+restic_check_exit_code 12
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				if got := strings.Join(args, " "); got != "check --json" {
+					t.Fatalf("unexpected arguments: %s", got)
+				}
+				return []byte(tt.output), tt.err, tt.exitCode
+			}
+
+			c := NewCheckCollector("restic", fakeExec, nil)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "restic_check_errors", "restic_check_exit_code"); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_CheckTime(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`{"message_type":"summary","num_errors":0}`), nil, 0
+	}
+
+	start := time.Now()
+	c := NewCheckCollector("restic", fakeExec, nil)
+
+	ch := make(chan prometheus.Metric, 3)
+	c.Collect(ch)
+	close(ch)
+	for m := range ch {
+		if m.Desc() != checkTimeDesc {
+			continue
+		}
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		if got := metric.GetGauge().GetValue(); got < float64(start.Unix()) || got > float64(time.Now().Unix()) {
+			t.Errorf("restic_check_time_seconds = %v, want the start of the check", got)
+		}
+		return
+	}
+	t.Error("restic_check_time_seconds was not collected")
+}
+
+func TestCollector_Collect_InvalidJsonOutput(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`{{`), nil, 0
+	}
+
+	parseErrors := prometheus.NewCounter(prometheus.CounterOpts{Name: "parse_errors"})
+	c := NewCheckCollector("restic", fakeExec, parseErrors)
+
+	want := `
+# HELP restic_check_exit_code Exit code of the last check command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_check_exit_code gauge
+restic_check_exit_code 1684
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+	if got := testutil.ToFloat64(parseErrors); got != 1 {
+		t.Errorf("parse errors = %v, want 1", got)
+	}
+}
+
+func TestCollector_LastCheck(t *testing.T) {
+	exitCode := 12
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		if exitCode != 1 {
+			return nil, errors.New("exit status 12"), exitCode
+		}
+		return []byte(`{"message_type":"summary","num_errors":2}`), errors.New("exit status 1"), exitCode
+	}
+
+	c := NewCheckCollector("restic", fakeExec, nil)
+	c.cache.Current(t.Context(), c.refresh)
+	if _, _, ok := c.LastCheck(); ok {
+		t.Fatal("LastCheck() ok = true before a check finished")
+	}
+
+	exitCode = 1
+	c.cache.Current(t.Context(), c.refresh)
+	numErrors, checkedAt, ok := c.LastCheck()
+	if !ok || numErrors != 2 || checkedAt.IsZero() {
+		t.Errorf("LastCheck() = %d, %v, %v, want 2 errors of a finished check", numErrors, checkedAt, ok)
+	}
+}
+
+func TestCollector_Run_DoesNotCheckOnStart(t *testing.T) {
+	checked := make(chan struct{}, 1)
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		checked <- struct{}{}
+		return []byte(`{"message_type":"summary","num_errors":0}`), nil, 0
+	}
+
+	c := NewCheckCollector("restic", fakeExec, nil)
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, time.Hour)
+	}()
+
+	select {
+	case <-checked:
+		t.Error("Run() checked the repository on start")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Errorf("Collect() exported %d metrics before the first check", count)
+	}
+	cancel()
+	<-done
+}
+
+func TestCollector_Run_AfterRestore(t *testing.T) {
+	checked := make(chan struct{}, 1)
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		checked <- struct{}{}
+		return []byte(`{"message_type":"summary","num_errors":0}`), nil, 0
+	}
+
+	c := NewCheckCollector("restic", fakeExec, nil)
+	checkedAt := time.Now().Add(-time.Hour + 100*time.Millisecond)
+	c.Restore(2, checkedAt)
+	if numErrors, at, ok := c.LastCheck(); !ok || numErrors != 2 || !at.Equal(checkedAt) {
+		t.Errorf("LastCheck() = %d, %v, %v, want the restored check", numErrors, at, ok)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go c.Run(ctx, time.Hour)
+
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		t.Error("Run() did not check one interval after the restored check")
+	}
+}
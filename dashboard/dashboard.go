@@ -0,0 +1,179 @@
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/status"
+	"slices"
+	"strings"
+	"time"
+)
+
+//go:embed templates/index.html
+var templates embed.FS
+
+var indexTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
+	"formatTime":     formatTime,
+	"formatDuration": formatDuration,
+	"formatBytes":    formatBytes,
+}).ParseFS(templates, "templates/index.html"))
+
+// SnapshotSource provides the snapshot groups of the last successful refresh of a repository.
+type SnapshotSource interface {
+	GroupData() ([]snapshot.GroupData, time.Time, bool)
+}
+
+// Repository is a repository shown on the dashboard.
+type Repository struct {
+	Name       string
+	StaleAfter time.Duration
+	// GroupStaleAfter overrides StaleAfter for single groups, keyed by hostname/tags with the tags sorted and joined by commas.
+	GroupStaleAfter map[string]time.Duration
+	Snapshots       SnapshotSource
+	Status          *status.Repository
+}
+
+type pageView struct {
+	Now          time.Time
+	Repositories []repositoryView
+}
+
+type repositoryView struct {
+	Name       string
+	StaleAfter time.Duration
+	Status     status.RepositoryStatus
+	Refreshed  bool
+	Groups     []groupView
+}
+
+type groupView struct {
+	Hostname      string
+	Tags          string
+	SnapshotCount int
+	LastSnapshot  time.Time
+	Age           time.Duration
+	Duration      time.Duration
+	DataAdded     int
+	// State is fresh, stale or none if the group has no snapshots.
+	State string
+}
+
+type handler struct {
+	repositories []Repository
+	now          func() time.Time
+}
+
+// NewHandler returns a handler serving an HTML overview of the backup state of all repositories at /.
+func NewHandler(repositories []Repository) http.Handler {
+	return &handler{repositories: repositories, now: time.Now}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, h.page()); err != nil {
+		slog.Error("Failed to write dashboard", "error", err)
+	}
+}
+
+func (h *handler) page() pageView {
+	now := h.now()
+	page := pageView{Now: now}
+
+	for _, repository := range h.repositories {
+		view := repositoryView{Name: repository.Name, StaleAfter: repository.StaleAfter}
+		if repository.Status != nil {
+			view.Status = repository.Status.Status()
+		}
+
+		groupData, _, ok := repository.Snapshots.GroupData()
+		view.Refreshed = ok
+		for _, group := range groupData {
+			view.Groups = append(view.Groups, newGroupView(group, now, repository.staleAfter(group.GroupKey)))
+		}
+
+		page.Repositories = append(page.Repositories, view)
+	}
+
+	return page
+}
+
+// staleAfter returns the age after which the last snapshot of the group with key is stale.
+func (r Repository) staleAfter(key snapshot.GroupKey) time.Duration {
+	tags := slices.Clone(key.Tags)
+	slices.Sort(tags)
+	if staleAfter, ok := r.GroupStaleAfter[key.Hostname+"/"+strings.Join(tags, ",")]; ok {
+		return staleAfter
+	}
+	return r.StaleAfter
+}
+
+func newGroupView(group snapshot.GroupData, now time.Time, staleAfter time.Duration) groupView {
+	view := groupView{
+		Hostname:      group.GroupKey.Hostname,
+		Tags:          strings.Join(group.GroupKey.Tags, ", "),
+		SnapshotCount: group.SnapshotCount(),
+		State:         "none",
+	}
+
+	if len(group.Snapshots) == 0 {
+		return view
+	}
+
+	last := group.Snapshots[0]
+	for _, s := range group.Snapshots {
+		if s.Time.After(last.Time) {
+			last = s
+		}
+	}
+
+	view.LastSnapshot = last.Time
+	view.Age = now.Sub(last.Time)
+	view.Duration = last.Summary.BackupEnd.Sub(last.Summary.BackupStart)
+	view.DataAdded = last.Summary.DataAdded
+	view.State = "fresh"
+	if view.Age > staleAfter {
+		view.State = "stale"
+	}
+
+	return view
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("%dd %s", days, (d % (24 * time.Hour)).String())
+	}
+	return d.String()
+}
+
+func formatBytes(b int) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := unit, 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,147 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSnapshotSource struct {
+	groupData []snapshot.GroupData
+	ok        bool
+}
+
+func (f fakeSnapshotSource) GroupData() ([]snapshot.GroupData, time.Time, bool) {
+	return f.groupData, time.Time{}, f.ok
+}
+
+var now = time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC)
+
+func newTestHandler(repositories []Repository) *handler {
+	return &handler{repositories: repositories, now: func() time.Time { return now }}
+}
+
+func TestHandler(t *testing.T) {
+	repositoryStatus := status.NewRegistry().Add("offsite")
+	repositoryStatus.Record(now, time.Second, 1, "Fatal: unable to open repository")
+
+	h := newTestHandler([]Repository{
+		{
+			Name:       "local",
+			StaleAfter: 24 * time.Hour,
+			Snapshots: fakeSnapshotSource{ok: true, groupData: []snapshot.GroupData{
+				{
+					GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}},
+					Snapshots: []snapshot.Snapshot{{
+						Time: now.Add(-2 * time.Hour),
+						Summary: snapshot.Summary{
+							BackupStart: now.Add(-2 * time.Hour),
+							BackupEnd:   now.Add(-2*time.Hour + 90*time.Second),
+							DataAdded:   1536,
+						},
+					}},
+				},
+				{
+					GroupKey:  snapshot.GroupKey{Hostname: "DPC1", Tags: []string{"full-server", "daily"}},
+					Snapshots: []snapshot.Snapshot{{Time: now.Add(-50 * time.Hour)}},
+				},
+			}},
+		},
+		{
+			Name:       "offsite",
+			StaleAfter: 24 * time.Hour,
+			Snapshots:  fakeSnapshotSource{},
+			Status:     repositoryStatus,
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<h2>local</h2>",
+		`<tr class="fresh">`,
+		"<td>SK12</td>",
+		"<td>2h0m0s</td>",
+		"<td>1m30s</td>",
+		"<td>1.5 KiB</td>",
+		`<tr class="stale">`,
+		"<td>full-server, daily</td>",
+		"<td>2d 2h0m0s</td>",
+		"<h2>offsite</h2>",
+		"exit code: 1",
+		"Fatal: unable to open repository",
+		"No snapshot data available yet.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body does not contain %q", want)
+		}
+	}
+}
+
+func TestHandler_GroupStaleAfter(t *testing.T) {
+	h := newTestHandler([]Repository{{
+		Name:            "local",
+		StaleAfter:      24 * time.Hour,
+		GroupStaleAfter: map[string]time.Duration{"DPC1/daily,full-server": 192 * time.Hour},
+		Snapshots: fakeSnapshotSource{ok: true, groupData: []snapshot.GroupData{
+			{
+				GroupKey:  snapshot.GroupKey{Hostname: "DPC1", Tags: []string{"full-server", "daily"}},
+				Snapshots: []snapshot.Snapshot{{Time: now.Add(-50 * time.Hour)}},
+			},
+			{
+				GroupKey:  snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}},
+				Snapshots: []snapshot.Snapshot{{Time: now.Add(-50 * time.Hour)}},
+			},
+		}},
+	}})
+
+	groups := h.page().Repositories[0].Groups
+	if got := groups[0].State; got != "fresh" {
+		t.Errorf("state of DPC1 = %q, want fresh", got)
+	}
+	if got := groups[1].State; got != "stale" {
+		t.Errorf("state of SK12 = %q, want stale", got)
+	}
+}
+
+func TestHandler_NotFound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		bytes int
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 * 1024 * 1024 * 1024 / 2, "1.5 GiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatBytes(tt.bytes); got != tt.want {
+				t.Errorf("formatBytes(%d) = %q, want %q", tt.bytes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// excludedMetrics are left out of the compared metrics because their values change between runs.
+var excludedMetrics = map[string]bool{
+	"restic_exporter_build_info":               true,
+	"restic_exporter_command_duration_seconds": true,
+	"restic_snapshot_cache_age_seconds":        true,
+	"restic_stats_cache_age_seconds":           true,
+}
+
+var (
+	exporterPath   string
+	fakeResticPath string
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		fmt.Println("skipping end-to-end tests in short mode")
+		os.Exit(0)
+	}
+
+	dir, err := os.MkdirTemp("", "restic-stats-exporter-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	exporterPath = filepath.Join(dir, "restic-stats-exporter")
+	fakeResticPath = filepath.Join(dir, "restic")
+	for path, pkg := range map[string]string{exporterPath: "..", fakeResticPath: "../internal/fakerestic"} {
+		if out, err := exec.Command("go", "build", "-o", path, pkg).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "build %s: %v\n%s", pkg, err, out)
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// invocation is a line of the log written by the fake restic binary.
+type invocation struct {
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env"`
+}
+
+type exporter struct {
+	url     string
+	logPath string
+	output  *bytes.Buffer
+}
+
+// startExporter runs the exporter against the fake restic binary serving the fixtures of scenario.
+// The repository is refreshed in the background, so the served metrics don't change between scrapes.
+func startExporter(t *testing.T, scenario string) *exporter {
+	t.Helper()
+
+	fixtures, err := filepath.Abs(filepath.Join("testdata", scenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &exporter{
+		url:     "http://" + freeAddress(t),
+		logPath: filepath.Join(t.TempDir(), "invocations.log"),
+		output:  &bytes.Buffer{},
+	}
+
+	cmd := exec.Command(exporterPath)
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"RESTIC_REPOSITORY=/srv/restic-repo",
+		"RESTIC_PASSWORD=hunter2",
+		"RSE_RESTIC_EXECUTABLE_PATH=" + fakeResticPath,
+		"RSE_LISTEN_ADDRESS=" + strings.TrimPrefix(e.url, "http://"),
+		"RSE_COMMAND_TIMEOUT=500ms",
+		"RSE_REFRESH_INTERVAL=1h",
+		"FAKE_RESTIC_FIXTURES=" + fixtures,
+		"FAKE_RESTIC_LOG=" + e.logPath,
+	}
+	cmd.Stdout = e.output
+	cmd.Stderr = e.output
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("exporter output:\n%s", e.output)
+		}
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(e.url + "/-/healthy")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return e
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("exporter did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// scrape waits for the first background refresh of the snapshots and statistics to finish
+// and returns the restic metrics served by the exporter in the text format, sorted by name.
+func (e *exporter) scrape(t *testing.T) string {
+	t.Helper()
+
+	var families map[string]*dto.MetricFamily
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		families = e.gather(t)
+		if families["restic_snapshot_exit_code"] != nil && families["restic_stats_exit_code"] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("first refresh did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		if strings.HasPrefix(name, "restic_") && !excludedMetrics[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		if _, err := expfmt.MetricFamilyToText(&buf, families[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func (e *exporter) gather(t *testing.T) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	resp, err := http.Get(e.url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func (e *exporter) invocations(t *testing.T) []invocation {
+	t.Helper()
+
+	f, err := os.Open(e.logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var invocations []invocation
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var i invocation
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			t.Fatal(err)
+		}
+		invocations = append(invocations, i)
+	}
+	return invocations
+}
+
+func (e *exporter) status(t *testing.T) []map[string]any {
+	t.Helper()
+
+	resp, err := http.Get(e.url + "/status?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var statuses []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	return statuses
+}
+
+func TestExporter(t *testing.T) {
+	tests := []struct {
+		scenario   string
+		wantStderr string
+	}{
+		{scenario: "success"},
+		{
+			scenario:   "failure",
+			wantStderr: "Fatal: unable to open config file: stat /srv/restic-repo/config: no such file or directory",
+		},
+		{scenario: "timeout", wantStderr: "command timed out after 500ms: signal: killed"},
+		{scenario: "malformed_json", wantStderr: "invalid output: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			t.Parallel()
+
+			e := startExporter(t, tt.scenario)
+			got := e.scrape(t)
+
+			golden := filepath.Join("testdata", tt.scenario, "metrics.prom")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+			}
+
+			statuses := e.status(t)
+			if len(statuses) != 1 {
+				t.Fatalf("statuses = %v, want 1", statuses)
+			}
+			if stderr, _ := statuses[0]["stderr"].(string); stderr != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestExporter_Invocations(t *testing.T) {
+	e := startExporter(t, "success")
+	e.scrape(t)
+
+	byCommand := map[string]invocation{}
+	for _, i := range e.invocations(t) {
+		byCommand[i.Args[0]] = i
+	}
+
+	wantArgs := map[string][]string{
+		"snapshots": {"snapshots", "--json", "--no-lock", "--group-by", "host,tags"},
+		"stats":     {"stats", "--json", "--no-lock", "--mode", "raw-data"},
+	}
+	if len(byCommand) != len(wantArgs) {
+		t.Errorf("invoked commands = %v, want %v", byCommand, wantArgs)
+	}
+	for command, want := range wantArgs {
+		i := byCommand[command]
+		if !reflect.DeepEqual(i.Args, want) {
+			t.Errorf("%s arguments = %q, want %q", command, i.Args, want)
+		}
+
+		wantEnv := map[string]string{"RESTIC_REPOSITORY": "/srv/restic-repo", "RESTIC_PASSWORD": "hunter2"}
+		if !reflect.DeepEqual(i.Env, wantEnv) {
+			t.Errorf("%s environment = %v, want %v", command, i.Env, wantEnv)
+		}
+	}
+}
+
+// freeAddress returns a local address with a currently unused port.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
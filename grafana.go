@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"restic-stats-exporter/grafana"
+)
+
+// runDashboard writes the Grafana dashboard for the exported metrics to stdout.
+func runDashboard() {
+	data, err := json.MarshalIndent(grafana.New(), "", "  ")
+	if err != nil {
+		slog.Error("Failed to generate dashboard", "error", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stdout.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write dashboard", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,410 @@
+package grafana
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Dashboard is a Grafana dashboard as imported from JSON.
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Editable      bool       `json:"editable"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard template variable.
+type Variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Query      any         `json:"query"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	Multi      bool        `json:"multi"`
+	IncludeAll bool        `json:"includeAll"`
+	AllValue   string      `json:"allValue,omitempty"`
+}
+
+type Datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type Panel struct {
+	ID          int         `json:"id"`
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Description string      `json:"description,omitempty"`
+	GridPos     GridPos     `json:"gridPos"`
+	Datasource  Datasource  `json:"datasource"`
+	FieldConfig FieldConfig `json:"fieldConfig"`
+	Targets     []Target    `json:"targets"`
+}
+
+type GridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type FieldConfig struct {
+	Defaults FieldDefaults `json:"defaults"`
+}
+
+type FieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+// Target is a PromQL query of a panel.
+type Target struct {
+	RefID        string     `json:"refId"`
+	Expr         string     `json:"expr"`
+	LegendFormat string     `json:"legendFormat,omitempty"`
+	Datasource   Datasource `json:"datasource"`
+}
+
+var datasource = Datasource{Type: "prometheus", UID: "${datasource}"}
+
+const (
+	// repositorySelector selects the series of the repositories chosen in the dashboard variables.
+	repositorySelector = `{repository=~"$repository"}`
+	// groupSelector selects the series of the snapshot groups chosen in the dashboard variables.
+	groupSelector = `{repository=~"$repository", restic_hostname=~"$hostname", restic_tags=~"$tags"}`
+
+	groupLegend      = "{{repository}} {{restic_hostname}} {{restic_tags}}"
+	repositoryLegend = "{{repository}}"
+)
+
+// panelSpec describes a panel before it is laid out on the dashboard grid.
+type panelSpec struct {
+	panelType   string
+	title       string
+	description string
+	unit        string
+	targets     []Target
+}
+
+// New returns the dashboard covering the snapshot and repository metrics of the exporter.
+func New() Dashboard {
+	specs := []panelSpec{
+		{
+			panelType: "stat",
+			title:     "Snapshots",
+			unit:      "short",
+			targets:   []Target{target("restic_snapshot_count_total"+repositorySelector, repositoryLegend)},
+		},
+		{
+			panelType: "stat",
+			title:     "Exit codes",
+			description: "Exit code of the last restic invocations, 1684 for unparsable output. " +
+				"See https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes",
+			unit: "none",
+			targets: []Target{
+				target("restic_snapshot_exit_code"+repositorySelector, "snapshots {{repository}}"),
+				target("restic_stats_exit_code"+repositorySelector, "stats {{repository}}"),
+				target("restic_lock_exit_code"+repositorySelector, "locks {{repository}}"),
+				target("restic_check_exit_code"+repositorySelector, "check {{repository}}"),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Snapshots per group",
+			unit:      "short",
+			targets:   []Target{target("restic_snapshot_count"+groupSelector, groupLegend)},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Last snapshot age",
+			unit:      "s",
+			targets:   []Target{target("time() - restic_last_snapshot_time_seconds"+groupSelector, groupLegend)},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Last backup duration",
+			unit:      "s",
+			targets: []Target{target(
+				"restic_last_snapshot_backup_end_seconds"+groupSelector+" - restic_last_snapshot_backup_start_seconds"+groupSelector,
+				groupLegend,
+			)},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Data added by last backup",
+			unit:      "bytes",
+			targets: []Target{
+				target("restic_last_snapshot_data_added_bytes"+groupSelector, "added "+groupLegend),
+				target("restic_last_snapshot_data_added_packed_bytes"+groupSelector, "added packed "+groupLegend),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Files of last backup",
+			unit:      "short",
+			targets: []Target{
+				target("restic_last_snapshot_files_new"+groupSelector, "new "+groupLegend),
+				target("restic_last_snapshot_files_changed"+groupSelector, "changed "+groupLegend),
+				target("restic_last_snapshot_files_unmodified"+groupSelector, "unmodified "+groupLegend),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Directories of last backup",
+			unit:      "short",
+			targets: []Target{
+				target("restic_last_snapshot_dirs_new"+groupSelector, "new "+groupLegend),
+				target("restic_last_snapshot_dirs_changed"+groupSelector, "changed "+groupLegend),
+				target("restic_last_snapshot_dirs_unmodified"+groupSelector, "unmodified "+groupLegend),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Blobs added by last backup",
+			unit:      "short",
+			targets: []Target{
+				target("restic_last_snapshot_data_blobs"+groupSelector, "data "+groupLegend),
+				target("restic_last_snapshot_tree_blobs"+groupSelector, "tree "+groupLegend),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Processed by last backup",
+			unit:      "bytes",
+			targets: []Target{
+				target("restic_last_snapshot_total_bytes_processed"+groupSelector, "bytes "+groupLegend),
+				target("restic_last_snapshot_total_files_processed"+groupSelector, "files "+groupLegend),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Repository size",
+			unit:      "bytes",
+			targets: []Target{
+				target("restic_repository_total_size_bytes"+repositorySelector, "packed {{repository}}"),
+				target("restic_repository_total_uncompressed_size_bytes"+repositorySelector, "uncompressed {{repository}}"),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Repository contents",
+			unit:      "short",
+			targets: []Target{
+				target("restic_repository_total_blob_count"+repositorySelector, "blobs {{repository}}"),
+				target("restic_repository_snapshot_count"+repositorySelector, "snapshots {{repository}}"),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Compression",
+			unit:      "percent",
+			targets: []Target{
+				target("restic_repository_compression_progress_percent"+repositorySelector, "compressed {{repository}}"),
+				target("restic_repository_compression_space_saving_percent"+repositorySelector, "space saving {{repository}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Cache age",
+			description: "Age of the served data when refreshing in the background, including data restored from the state file",
+			unit:        "s",
+			targets: []Target{
+				target("restic_snapshot_cache_age_seconds"+repositorySelector, "snapshots {{repository}}"),
+				target("restic_stats_cache_age_seconds"+repositorySelector, "stats {{repository}}"),
+				target("restic_lock_cache_age_seconds"+repositorySelector, "locks {{repository}}"),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "Compression ratio",
+			unit:      "none",
+			targets:   []Target{target("restic_repository_compression_ratio"+repositorySelector, repositoryLegend)},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Backup scope changes",
+			description: "1 if the paths or excludes of the last snapshot differ from the snapshot before or from the configured ones",
+			unit:        "none",
+			targets: []Target{
+				target("restic_last_snapshot_scope_changed"+groupSelector, "changed "+groupLegend),
+				target("restic_last_snapshot_scope_unexpected"+groupSelector, "unexpected "+groupLegend),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Processed data relative to usual",
+			description: "Bytes and files processed by the last snapshot relative to the median of the snapshots before",
+			unit:        "percentunit",
+			targets: []Target{
+				target("restic_last_snapshot_bytes_processed_ratio"+groupSelector, "bytes "+groupLegend),
+				target("restic_last_snapshot_files_processed_ratio"+groupSelector, "files "+groupLegend),
+				target("restic_last_snapshot_anomalous"+groupSelector+" == 1", "anomalous "+groupLegend),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Groups per tag",
+			description: "Number of snapshot groups containing a tag, only reported with tag_series enabled",
+			unit:        "short",
+			targets: []Target{
+				target("count by (repository, tag) (restic_group_tag"+groupSelector+")", "{{repository}} {{tag}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Client versions",
+			description: "Number of groups by the restic version and user that created their last snapshot",
+			unit:        "short",
+			targets: []Target{
+				target("count by (program_version, username) (restic_last_snapshot_info"+groupSelector+")", "{{program_version}} {{username}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Dropped series",
+			description: "Group series not exported because of relabel rules or max_groups, and the number of groups over max_groups",
+			unit:        "short",
+			targets: []Target{
+				target("restic_snapshot_dropped_series"+repositorySelector, "{{reason}} {{repository}}"),
+				target("restic_snapshot_groups_overflow"+repositorySelector, "groups over limit {{repository}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "rest-server size",
+			description: "On-disk size of the repositories on rest-server, only reported with rest_server enabled",
+			unit:        "bytes",
+			targets: []Target{
+				target("restic_rest_server_size_bytes"+repositorySelector, "{{type}} {{repository}}"),
+				target("restic_rest_server_file_count"+repositorySelector, "files {{type}} {{repository}}"),
+			},
+		},
+		{
+			panelType: "timeseries",
+			title:     "rest-server state",
+			unit:      "none",
+			targets: []Target{
+				target("restic_rest_server_up"+repositorySelector, "up {{repository}}"),
+				target("restic_rest_server_append_only"+repositorySelector, "append-only {{repository}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Locks",
+			description: "Number and age of the repository locks, only reported with locks enabled",
+			unit:        "none",
+			targets: []Target{
+				target("restic_lock_count"+repositorySelector, "locks {{repository}}"),
+				target("time() - restic_lock_oldest_time_seconds"+repositorySelector, "oldest lock age {{repository}}"),
+			},
+		},
+		{
+			panelType:   "timeseries",
+			title:       "Check",
+			description: "Errors found by the last finished restic check, only reported with check enabled",
+			unit:        "none",
+			targets: []Target{
+				target("restic_check_errors"+repositorySelector, "errors {{repository}}"),
+				target("time() - restic_check_time_seconds"+repositorySelector, "check age {{repository}}"),
+			},
+		},
+	}
+
+	return Dashboard{
+		UID:           "restic-stats-exporter",
+		Title:         "restic",
+		Tags:          []string{"restic", "backup"},
+		Editable:      true,
+		SchemaVersion: 39,
+		Refresh:       "5m",
+		Time:          TimeRange{From: "now-7d", To: "now"},
+		Templating: Templating{List: []Variable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			queryVariable("repository", "Repository", "label_values(restic_snapshot_exit_code, repository)"),
+			queryVariable("hostname", "Host", `label_values(restic_snapshot_count{repository=~"$repository"}, restic_hostname)`),
+			queryVariable("tags", "Tags", `label_values(restic_snapshot_count{repository=~"$repository", restic_hostname=~"$hostname"}, restic_tags)`),
+		}},
+		Panels: layout(specs),
+	}
+}
+
+// layout places the panels in two columns, the stat panels in the first row.
+func layout(specs []panelSpec) []Panel {
+	panels := make([]Panel, 0, len(specs))
+	x, y := 0, 0
+	for i, spec := range specs {
+		height := 8
+		if spec.panelType == "stat" {
+			height = 4
+		}
+
+		targets := make([]Target, len(spec.targets))
+		for j, t := range spec.targets {
+			t.RefID = string(rune('A' + j))
+			targets[j] = t
+		}
+
+		panels = append(panels, Panel{
+			ID:          i + 1,
+			Type:        spec.panelType,
+			Title:       spec.title,
+			Description: spec.description,
+			GridPos:     GridPos{X: x, Y: y, W: 12, H: height},
+			Datasource:  datasource,
+			FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: spec.unit}},
+			Targets:     targets,
+		})
+
+		x += 12
+		if x == 24 {
+			x = 0
+			y += height
+		}
+	}
+	return panels
+}
+
+func target(expr string, legendFormat string) Target {
+	return Target{Expr: expr, LegendFormat: strings.TrimSpace(legendFormat), Datasource: datasource}
+}
+
+func queryVariable(name string, label string, query string) Variable {
+	return Variable{
+		Name:       name,
+		Label:      label,
+		Type:       "query",
+		Query:      map[string]string{"query": query, "refId": "PrometheusVariableQueryEditor-VariableQuery"},
+		Datasource: &datasource,
+		// refresh the values when the time range changes
+		Refresh:    2,
+		Multi:      true,
+		IncludeAll: true,
+		AllValue:   ".*",
+	}
+}
+
+// Handler serves the dashboard as JSON for import into Grafana.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(New()); err != nil {
+			slog.Error("Failed to write Grafana dashboard", "error", err)
+		}
+	})
+}
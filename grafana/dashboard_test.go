@@ -0,0 +1,143 @@
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"restic-stats-exporter/check"
+	"restic-stats-exporter/lock"
+	"restic-stats-exporter/restserver"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	fqNamePattern     = regexp.MustCompile(`fqName: "([^"]+)"`)
+	metricNamePattern = regexp.MustCompile(`restic_[a-z_]+`)
+	selectorPattern   = regexp.MustCompile(`\{[^}]*\}`)
+)
+
+// describedMetrics returns the names of the metrics described by the snapshot, statistic, rest-server, lock and check collectors.
+func describedMetrics(t *testing.T) map[string]bool {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		snapshot.NewSnapshotCollector("restic", nil, nil, nil).Describe(ch)
+		statistic.NewStatisticCollector("restic", nil, nil).Describe(ch)
+		restServer, err := restserver.NewCollector("rest:http://localhost:8000/", "", "", nil)
+		if err != nil {
+			panic(err)
+		}
+		restServer.Describe(ch)
+		lock.NewLockCollector("restic", nil, nil).Describe(ch)
+		check.NewCheckCollector("restic", nil, nil).Describe(ch)
+		close(ch)
+	}()
+
+	names := map[string]bool{}
+	for desc := range ch {
+		match := fqNamePattern.FindStringSubmatch(desc.String())
+		if match == nil {
+			t.Fatalf("no metric name in %s", desc)
+		}
+		names[match[1]] = true
+	}
+	return names
+}
+
+// dashboardMetrics returns the names of the metrics queried by the dashboard panels.
+func dashboardMetrics(dashboard Dashboard) map[string]bool {
+	names := map[string]bool{}
+	for _, panel := range dashboard.Panels {
+		for _, target := range panel.Targets {
+			for _, name := range metricNamePattern.FindAllString(selectorPattern.ReplaceAllString(target.Expr, ""), -1) {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+func TestNew_MatchesDescriptors(t *testing.T) {
+	described := describedMetrics(t)
+	queried := dashboardMetrics(New())
+
+	for _, name := range sortedKeys(queried) {
+		if !described[name] {
+			t.Errorf("dashboard queries %s, which is not exported", name)
+		}
+	}
+	for _, name := range sortedKeys(described) {
+		if !queried[name] {
+			t.Errorf("exported metric %s is missing in the dashboard", name)
+		}
+	}
+}
+
+func TestNew_Variables(t *testing.T) {
+	var names []string
+	for _, variable := range New().Templating.List {
+		names = append(names, variable.Name)
+	}
+
+	want := []string{"datasource", "repository", "hostname", "tags"}
+	if len(names) != len(want) {
+		t.Fatalf("variables = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("variables = %v, want %v", names, want)
+		}
+	}
+}
+
+func TestNew_Panels(t *testing.T) {
+	ids := map[int]bool{}
+	for _, panel := range New().Panels {
+		if ids[panel.ID] {
+			t.Errorf("duplicate panel id %d", panel.ID)
+		}
+		ids[panel.ID] = true
+
+		if len(panel.Targets) == 0 {
+			t.Errorf("panel %q has no targets", panel.Title)
+		}
+		if panel.GridPos.X+panel.GridPos.W > 24 {
+			t.Errorf("panel %q exceeds the grid width", panel.Title)
+		}
+	}
+
+	if _, err := json.Marshal(New()); err != nil {
+		t.Errorf("json.Marshal() error = %v", err)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard.json", nil))
+
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/json")
+	}
+
+	var dashboard Dashboard
+	if err := json.NewDecoder(rec.Body).Decode(&dashboard); err != nil {
+		t.Fatal(err)
+	}
+	if dashboard.UID != "restic-stats-exporter" {
+		t.Errorf("UID = %q", dashboard.UID)
+	}
+}
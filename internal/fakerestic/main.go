@@ -0,0 +1,113 @@
+// Command fakerestic is a stand-in for the restic binary in end-to-end tests.
+//
+// The output of a restic subcommand is read from files in the directory FAKE_RESTIC_FIXTURES:
+//
+//	<subcommand>.json    written to stdout
+//	<subcommand>.stderr  written to stderr
+//	<subcommand>.exit    exit code, 0 if missing
+//	<subcommand>.sleep   duration to wait before writing any output, e.g. 10s
+//
+// If FAKE_RESTIC_LOG is set, every invocation is appended to this file as a JSON line
+// with the arguments and the RESTIC_* environment variables.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Invocation is a line of the FAKE_RESTIC_LOG file.
+type Invocation struct {
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fail("no subcommand")
+	}
+	subcommand := os.Args[1]
+
+	if err := logInvocation(os.Getenv("FAKE_RESTIC_LOG")); err != nil {
+		fail("write log: %v", err)
+	}
+
+	dir := os.Getenv("FAKE_RESTIC_FIXTURES")
+	found := false
+	fixture := func(ext string) ([]byte, bool) {
+		data, err := os.ReadFile(filepath.Join(dir, subcommand+ext))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false
+		}
+		if err != nil {
+			fail("read fixture: %v", err)
+		}
+		found = true
+		return data, true
+	}
+
+	if data, ok := fixture(".sleep"); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(string(data)))
+		if err != nil {
+			fail("invalid sleep fixture: %v", err)
+		}
+		time.Sleep(d)
+	}
+
+	stdout, _ := fixture(".json")
+	stderr, _ := fixture(".stderr")
+	exitCode := 0
+	if data, ok := fixture(".exit"); ok {
+		var err error
+		if exitCode, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			fail("invalid exit fixture: %v", err)
+		}
+	}
+
+	if !found {
+		fail("no fixture for subcommand %s in %s", subcommand, dir)
+	}
+
+	os.Stdout.Write(stdout)
+	os.Stderr.Write(stderr)
+	os.Exit(exitCode)
+}
+
+func logInvocation(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	invocation := Invocation{Args: os.Args[1:], Env: map[string]string{}}
+	for _, e := range os.Environ() {
+		if name, value, _ := strings.Cut(e, "="); strings.HasPrefix(name, "RESTIC_") {
+			invocation.Env[name] = value
+		}
+	}
+
+	line, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fail(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, "fakerestic: "+format+"\n", a...)
+	os.Exit(1)
+}
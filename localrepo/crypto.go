@@ -0,0 +1,116 @@
+package localrepo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	ivSize  = aes.BlockSize
+	macSize = poly1305.TagSize
+	// overhead is the number of bytes encryption adds to the plaintext.
+	overhead = ivSize + macSize
+)
+
+// errUnauthenticated is returned if the MAC of a ciphertext does not match, e.g. because of a wrong key.
+var errUnauthenticated = errors.New("ciphertext verification failed")
+
+// macKey is the Poly1305-AES key, K encrypts the nonce and R is the Poly1305 key.
+type macKey struct {
+	K [16]byte
+	R [16]byte
+}
+
+// key is a restic encryption key, used both for the master key and the keys derived from a password.
+type key struct {
+	Encrypt [32]byte
+	MAC     macKey
+}
+
+// masterKeyJSON is the plaintext of the data in a key file.
+type masterKeyJSON struct {
+	MAC struct {
+		K []byte `json:"k"`
+		R []byte `json:"r"`
+	} `json:"mac"`
+	Encrypt []byte `json:"encrypt"`
+}
+
+// deriveKey derives the key that decrypts the master key from password like restic does with scrypt.
+func deriveKey(password string, salt []byte, n, r, p int) (*key, error) {
+	derived, err := scrypt.Key([]byte(password), salt, n, r, p, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &key{}
+	copy(k.Encrypt[:], derived[:32])
+	copy(k.MAC.K[:], derived[32:48])
+	copy(k.MAC.R[:], derived[48:])
+	return k, nil
+}
+
+// parseMasterKey decodes the decrypted data of a key file.
+func parseMasterKey(plaintext []byte) (*key, error) {
+	var m masterKeyJSON
+	if err := json.Unmarshal(plaintext, &m); err != nil {
+		return nil, fmt.Errorf("parse master key: %w", err)
+	}
+	if len(m.Encrypt) != 32 || len(m.MAC.K) != 16 || len(m.MAC.R) != 16 {
+		return nil, fmt.Errorf("parse master key: invalid key length")
+	}
+
+	k := &key{}
+	copy(k.Encrypt[:], m.Encrypt)
+	copy(k.MAC.K[:], m.MAC.K)
+	copy(k.MAC.R[:], m.MAC.R)
+	return k, nil
+}
+
+// mac computes the Poly1305-AES authenticator of msg, the one-time key is derived from nonce.
+func (k *key) mac(nonce, msg []byte) ([16]byte, error) {
+	block, err := aes.NewCipher(k.MAC.K[:])
+	if err != nil {
+		return [16]byte{}, err
+	}
+
+	var oneTimeKey [32]byte
+	copy(oneTimeKey[:16], k.MAC.R[:])
+	block.Encrypt(oneTimeKey[16:], nonce)
+
+	var out [16]byte
+	poly1305.Sum(&out, msg, &oneTimeKey)
+	return out, nil
+}
+
+// decrypt verifies and decrypts ciphertext in the restic format: IV, AES-256-CTR encrypted data and Poly1305-AES MAC.
+func (k *key) decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < overhead {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	iv := ciphertext[:ivSize]
+	data := ciphertext[ivSize : len(ciphertext)-macSize]
+	mac, err := k.mac(iv, data)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac[:], ciphertext[len(ciphertext)-macSize:]) != 1 {
+		return nil, errUnauthenticated
+	}
+
+	block, err := aes.NewCipher(k.Encrypt[:])
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(plaintext, data)
+	return plaintext, nil
+}
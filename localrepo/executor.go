@@ -0,0 +1,191 @@
+package localrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/util"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Exit codes restic reports for the errors of Open and for other fatal errors.
+const (
+	exitCodeFatal         = 1
+	exitCodeNotExist      = 10
+	exitCodeWrongPassword = 12
+)
+
+// NewCommandExecutor returns an executor that answers the restic commands run by the collectors from the repository
+// at path instead of running restic. The snapshot listings are returned as the collectors decode them, without calling
+// the consumer:
+//
+//	snapshots --json --group-by host,tags  []snapshot.GroupData retaining snapshot.DefaultSnapshotHistory snapshots per group
+//	snapshots --json                       []snapshot.Snapshot sorted by time
+//
+// The output of the other commands matches the output of restic, so the collectors parse it unchanged:
+//
+//	list snapshots
+//	cat snapshot <id>
+//	stats --json --mode raw-data
+//
+// The repository is opened on the first command and kept open, so the keys are derived and the index is read only once.
+func NewCommandExecutor(path, password string) util.StreamCommandExecutor {
+	var mu sync.Mutex
+	var repository *Repository
+
+	open := func() (*Repository, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if repository != nil {
+			return repository, nil
+		}
+		r, err := Open(path, password)
+		if err != nil {
+			return nil, err
+		}
+		repository = r
+		return r, nil
+	}
+
+	return func(ctx context.Context, consume util.Consumer, name string, arg ...string) (any, error, int) {
+		if err := ctx.Err(); err != nil {
+			return nil, err, -1
+		}
+		cmd, err := parseCommand(arg)
+		if err != nil {
+			return nil, err, exitCodeOf(err)
+		}
+		r, err := open()
+		if err != nil {
+			return nil, err, exitCodeOf(err)
+		}
+
+		if cmd.listsSnapshots() {
+			result, err := r.listSnapshots(cmd)
+			if err != nil {
+				return nil, err, exitCodeOf(err)
+			}
+			return result, nil, 0
+		}
+
+		output, err := r.output(cmd)
+		if err != nil {
+			return nil, err, exitCodeOf(err)
+		}
+
+		result, err := consume(strings.NewReader(output))
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", util.ErrInvalidOutput, err), 0
+		}
+		return result, nil, 0
+	}
+}
+
+// errUnsupportedCommand is returned for restic commands the executor can't answer.
+var errUnsupportedCommand = errors.New("unsupported command")
+
+// command is a restic command with the flags the executor understands.
+type command struct {
+	args       []string
+	positional []string
+	groupBy    string
+	mode       string
+}
+
+// parseCommand parses the restic arguments args.
+func parseCommand(args []string) (command, error) {
+	cmd := command{args: args}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--json", "--no-lock":
+		case "--group-by":
+			i++
+			if i < len(args) {
+				cmd.groupBy = args[i]
+			}
+		case "--mode":
+			i++
+			if i < len(args) {
+				cmd.mode = args[i]
+			}
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return command{}, fmt.Errorf("%w: flag %s", errUnsupportedCommand, args[i])
+			}
+			cmd.positional = append(cmd.positional, args[i])
+		}
+	}
+	return cmd, nil
+}
+
+// is reports whether positional are the positional arguments of cmd.
+func (cmd command) is(positional ...string) bool {
+	return slices.Equal(cmd.positional, positional)
+}
+
+// listsSnapshots reports whether cmd is restic snapshots, which is answered by listSnapshots.
+func (cmd command) listsSnapshots() bool {
+	return cmd.is("snapshots")
+}
+
+// listSnapshots returns the snapshots of the repository like the snapshot collector decodes the output of cmd.
+func (r *Repository) listSnapshots(cmd command) (any, error) {
+	switch cmd.groupBy {
+	case "":
+		return r.Snapshots()
+	case "host,tags":
+		return r.SnapshotGroups(snapshot.DefaultSnapshotHistory)
+	}
+	return nil, fmt.Errorf("%w: %s", errUnsupportedCommand, strings.Join(cmd.args, " "))
+}
+
+// output returns the output restic prints for cmd.
+func (r *Repository) output(cmd command) (string, error) {
+	switch {
+	case cmd.is("list", "snapshots"):
+		ids, err := r.SnapshotIDs()
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, id := range ids {
+			b.WriteString(id + "\n")
+		}
+		return b.String(), nil
+	case len(cmd.positional) == 3 && cmd.positional[0] == "cat" && cmd.positional[1] == "snapshot":
+		data, err := r.SnapshotFile(cmd.positional[2])
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case cmd.is("stats") && cmd.mode == "raw-data":
+		stats, err := r.Stats()
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", errUnsupportedCommand, strings.Join(cmd.args, " "))
+}
+
+// exitCodeOf returns the exit code restic reports for err.
+func exitCodeOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		return exitCodeNotExist
+	case errors.Is(err, ErrWrongPassword):
+		return exitCodeWrongPassword
+	default:
+		return exitCodeFatal
+	}
+}
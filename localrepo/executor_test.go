@@ -0,0 +1,149 @@
+package localrepo
+
+import (
+	"encoding/json"
+	"io"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewCommandExecutor_SnapshotCollector(t *testing.T) {
+	path := testdataRepository(2)
+
+	expected := `
+# HELP restic_snapshot_exit_code Exit code of the list snapshots command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_snapshot_exit_code gauge
+restic_snapshot_exit_code 0
+# HELP restic_snapshot_count_total Total number of snapshots in the repository
+# TYPE restic_snapshot_count_total gauge
+restic_snapshot_count_total 3
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="DPC1",restic_tags=""} 1
+restic_snapshot_count{restic_hostname="SK12",restic_tags="daily,kuma"} 2
+# HELP restic_last_snapshot_time_seconds Unix timestamp of the last snapshot
+# TYPE restic_last_snapshot_time_seconds gauge
+restic_last_snapshot_time_seconds{restic_hostname="DPC1",restic_tags=""} 1.7598384e+09
+restic_last_snapshot_time_seconds{restic_hostname="SK12",restic_tags="daily,kuma"} 1.759946161e+09
+`
+	names := []string{"restic_snapshot_exit_code", "restic_snapshot_count_total", "restic_snapshot_count", "restic_last_snapshot_time_seconds"}
+
+	full := snapshot.NewSnapshotCollector("", NewCommandExecutor(path, testPassword), nil, nil)
+	if err := testutil.CollectAndCompare(full, strings.NewReader(expected), names...); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+
+	incremental := snapshot.NewSnapshotCollector("", NewCommandExecutor(path, testPassword), nil, nil)
+	incremental.LoadIncrementally()
+	for i := 0; i < 2; i++ {
+		if err := testutil.CollectAndCompare(incremental, strings.NewReader(expected), names...); err != nil {
+			t.Errorf("unexpected incremental metrics output: %v", err)
+		}
+	}
+}
+
+func TestNewCommandExecutor_SnapshotListings(t *testing.T) {
+	executor := NewCommandExecutor(testdataRepository(2), testPassword)
+	consume := func(stdout io.Reader) (any, error) {
+		t.Error("snapshot listing passed to the consumer")
+		return nil, nil
+	}
+
+	out, err, _ := executor(t.Context(), consume, "restic", "snapshots", "--json", "--no-lock", "--group-by", "host,tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups, ok := out.([]snapshot.GroupData); !ok || len(groups) != 2 {
+		t.Errorf("grouped snapshots = %#v, want 2 groups", out)
+	}
+
+	out, err, _ = executor(t.Context(), consume, "restic", "snapshots", "--json", "--no-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, ok := out.([]snapshot.Snapshot); !ok || len(snapshots) != 3 {
+		t.Errorf("snapshots = %#v, want 3 snapshots", out)
+	}
+}
+
+func TestNewCommandExecutor_StatisticCollector(t *testing.T) {
+	path := testdataRepository(1)
+
+	expected := `
+# HELP restic_stats_exit_code Exit code of the stats command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_stats_exit_code gauge
+restic_stats_exit_code 0
+# HELP restic_repository_total_blob_count Total number of blobs in the repository
+# TYPE restic_repository_total_blob_count gauge
+restic_repository_total_blob_count 19
+# HELP restic_repository_snapshot_count Number of snapshots included in the repository statistics
+# TYPE restic_repository_snapshot_count gauge
+restic_repository_snapshot_count 3
+`
+	c := statistic.NewStatisticCollector("", NewCommandExecutor(path, testPassword).Output(), nil)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_stats_exit_code", "restic_repository_total_blob_count", "restic_repository_snapshot_count"); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestNewCommandExecutor_Errors(t *testing.T) {
+	path := testdataRepository(2)
+	consume := func(stdout io.Reader) (any, error) {
+		return nil, nil
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		password     string
+		args         []string
+		wantExitCode int
+	}{
+		{name: "missing repository", path: path + "/missing", password: testPassword, args: []string{"snapshots", "--json"}, wantExitCode: 10},
+		{name: "wrong password", path: path, password: "wrong", args: []string{"snapshots", "--json"}, wantExitCode: 12},
+		{name: "unsupported command", path: path, password: testPassword, args: []string{"check"}, wantExitCode: 1},
+		{name: "unsupported flag", path: path, password: testPassword, args: []string{"snapshots", "--latest", "1"}, wantExitCode: 1},
+		{name: "missing snapshot", path: path, password: testPassword, args: []string{"cat", "snapshot", strings.Repeat("0", 64)}, wantExitCode: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err, exitCode := NewCommandExecutor(tt.path, tt.password)(t.Context(), consume, "restic", tt.args...)
+			if err == nil || exitCode != tt.wantExitCode {
+				t.Errorf("executor error = %v, exit code = %d, want exit code %d", err, exitCode, tt.wantExitCode)
+			}
+		})
+	}
+}
+
+func TestNewCommandExecutor_ListAndCat(t *testing.T) {
+	var want []snapshot.Snapshot
+	readResticOutput(t, 2, "snapshots.json", &want)
+	executor := NewCommandExecutor(testdataRepository(2), testPassword).Output()
+
+	out, err, _ := executor(t.Context(), "restic", "list", "snapshots", "--no-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(out), "\n"); got != len(want) {
+		t.Errorf("list snapshots printed %d IDs, want %d", got, len(want))
+	}
+
+	out, err, _ = executor(t.Context(), "restic", "cat", "snapshot", want[0].ID, "--no-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Hostname string `json:"hostname"`
+		Tree     string `json:"tree"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("cat snapshot printed invalid JSON: %v", err)
+	}
+	if got.Hostname != want[0].Hostname || got.Tree == "" {
+		t.Errorf("cat snapshot printed %s, want the snapshot of %s with its tree", out, want[0].Hostname)
+	}
+}
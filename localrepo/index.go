@@ -0,0 +1,164 @@
+package localrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// blobHandle identifies a data or tree blob.
+type blobHandle struct {
+	ID   string
+	Tree bool
+}
+
+func (h blobHandle) String() string {
+	if h.Tree {
+		return "tree/" + h.ID[:8]
+	}
+	return "data/" + h.ID[:8]
+}
+
+// indexEntry locates a blob in a pack file.
+type indexEntry struct {
+	Pack               string
+	Offset             int
+	Length             int
+	UncompressedLength int
+}
+
+// dataLength returns the length of the plaintext of the blob.
+func (e indexEntry) dataLength() int {
+	if e.UncompressedLength != 0 {
+		return e.UncompressedLength
+	}
+	return e.Length - overhead
+}
+
+// indexFile is a file in the index directory.
+type indexFile struct {
+	Packs []struct {
+		ID    string `json:"id"`
+		Blobs []struct {
+			ID                 string `json:"id"`
+			Type               string `json:"type"`
+			Offset             int    `json:"offset"`
+			Length             int    `json:"length"`
+			UncompressedLength int    `json:"uncompressed_length"`
+		} `json:"blobs"`
+	} `json:"packs"`
+}
+
+// tree is the content of a tree blob, only the fields referencing other blobs are decoded.
+type tree struct {
+	Nodes []struct {
+		Type    string   `json:"type"`
+		Content []string `json:"content"`
+		Subtree string   `json:"subtree"`
+	} `json:"nodes"`
+}
+
+// loadIndex returns the blobs of all index files. The index is only read again if the index files changed since the last call.
+func (r *Repository) loadIndex() (map[blobHandle]indexEntry, error) {
+	names, err := listFiles(filepath.Join(r.path, "index"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index != nil && slices.Equal(names, r.indexFiles) {
+		return r.index, nil
+	}
+
+	index := map[blobHandle]indexEntry{}
+	for _, name := range names {
+		data, err := r.readFile("index", name)
+		if err != nil {
+			return nil, err
+		}
+		var f indexFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("parse index %s: %w", name, err)
+		}
+
+		for _, pack := range f.Packs {
+			for _, blob := range pack.Blobs {
+				handle := blobHandle{ID: blob.ID, Tree: blob.Type == "tree"}
+				if _, ok := index[handle]; ok {
+					continue
+				}
+				index[handle] = indexEntry{
+					Pack:               pack.ID,
+					Offset:             blob.Offset,
+					Length:             blob.Length,
+					UncompressedLength: blob.UncompressedLength,
+				}
+			}
+		}
+	}
+
+	r.index, r.indexFiles = index, names
+	return index, nil
+}
+
+// walkTree adds the tree with id and all blobs referenced by it to blobs. Trees already in blobs are skipped.
+func (r *Repository) walkTree(index map[blobHandle]indexEntry, id string, blobs map[blobHandle]bool) error {
+	handle := blobHandle{ID: id, Tree: true}
+	if blobs[handle] {
+		return nil
+	}
+	blobs[handle] = true
+
+	data, err := r.loadBlob(index, handle)
+	if err != nil {
+		return err
+	}
+	var t tree
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("parse tree %s: %w", handle, err)
+	}
+
+	for _, node := range t.Nodes {
+		for _, content := range node.Content {
+			blobs[blobHandle{ID: content}] = true
+		}
+		if node.Type == "dir" && node.Subtree != "" {
+			if err := r.walkTree(index, node.Subtree, blobs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadBlob reads and decodes the blob from its pack file.
+func (r *Repository) loadBlob(index map[blobHandle]indexEntry, handle blobHandle) ([]byte, error) {
+	entry, ok := index[handle]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found in index", handle)
+	}
+
+	f, err := os.Open(filepath.Join(r.path, "data", entry.Pack[:2], entry.Pack))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ciphertext := make([]byte, entry.Length)
+	if _, err := f.ReadAt(ciphertext, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("read blob %s: %w", handle, err)
+	}
+	plaintext, err := r.key.decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt blob %s: %w", handle, err)
+	}
+	if entry.UncompressedLength == 0 {
+		return plaintext, nil
+	}
+	return r.decoder.DecodeAll(plaintext, make([]byte, 0, entry.UncompressedLength))
+}
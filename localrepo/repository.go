@@ -0,0 +1,312 @@
+// Package localrepo reads the metadata of restic repositories on the local filesystem without the restic binary.
+// It supports repository format versions 1 and 2 and never writes to the repository.
+package localrepo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrNotExist is returned if there is no repository at the path.
+var ErrNotExist = errors.New("repository does not exist")
+
+// ErrWrongPassword is returned if no key of the repository can be opened with the password.
+var ErrWrongPassword = errors.New("wrong password or no key found")
+
+// Repository is an opened restic repository on the local filesystem.
+type Repository struct {
+	path    string
+	key     *key
+	version int
+	decoder *zstd.Decoder
+
+	mu         sync.Mutex
+	index      map[blobHandle]indexEntry
+	indexFiles []string
+}
+
+// keyFile is a file in the keys directory, data holds the encrypted master key.
+type keyFile struct {
+	KDF  string `json:"kdf"`
+	N    int    `json:"N"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+	Data []byte `json:"data"`
+}
+
+type configFile struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+}
+
+// snapshotFile is a file in the snapshots directory, which references the root tree of the snapshot.
+type snapshotFile struct {
+	snapshot.Snapshot
+	Tree string `json:"tree"`
+}
+
+// Open opens the repository at path with password.
+func Open(path, password string) (*Repository, error) {
+	if _, err := os.Stat(filepath.Join(path, "config")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w at %s", ErrNotExist, path)
+		}
+		return nil, err
+	}
+
+	masterKey, err := openKey(path, password)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	r := &Repository{path: path, key: masterKey, decoder: decoder}
+
+	data, err := os.ReadFile(filepath.Join(path, "config"))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := r.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var cfg configFile
+	if err := json.Unmarshal(plaintext, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if cfg.Version != 1 && cfg.Version != 2 {
+		return nil, fmt.Errorf("unsupported repository version %d", cfg.Version)
+	}
+	r.version = cfg.Version
+
+	return r, nil
+}
+
+// openKey tries all key files of the repository and returns the master key of the first that opens with password.
+func openKey(path, password string) (*key, error) {
+	names, err := listFiles(filepath.Join(path, "keys"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(path, "keys", name))
+		if err != nil {
+			return nil, err
+		}
+		var kf keyFile
+		if err := json.Unmarshal(data, &kf); err != nil {
+			return nil, fmt.Errorf("parse key %s: %w", name, err)
+		}
+		if kf.KDF != "scrypt" {
+			continue
+		}
+
+		userKey, err := deriveKey(password, kf.Salt, kf.N, kf.R, kf.P)
+		if err != nil {
+			return nil, fmt.Errorf("derive key %s: %w", name, err)
+		}
+		plaintext, err := userKey.decrypt(kf.Data)
+		if errors.Is(err, errUnauthenticated) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decrypt key %s: %w", name, err)
+		}
+		return parseMasterKey(plaintext)
+	}
+
+	return nil, ErrWrongPassword
+}
+
+// Version returns the repository format version.
+func (r *Repository) Version() int {
+	return r.version
+}
+
+// SnapshotIDs returns the IDs of all snapshots in the repository.
+func (r *Repository) SnapshotIDs() ([]string, error) {
+	return listFiles(filepath.Join(r.path, "snapshots"))
+}
+
+// SnapshotFile returns the decrypted content of the snapshot file with id, like restic cat snapshot.
+func (r *Repository) SnapshotFile(id string) ([]byte, error) {
+	return r.readFile("snapshots", id)
+}
+
+// Snapshots returns all snapshots of the repository sorted by time.
+func (r *Repository) Snapshots() ([]snapshot.Snapshot, error) {
+	files, err := r.snapshotFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]snapshot.Snapshot, 0, len(files))
+	for _, f := range files {
+		snapshots = append(snapshots, f.Snapshot)
+	}
+	return snapshots, nil
+}
+
+// SnapshotGroups returns the snapshots of the repository grouped like restic snapshots --group-by host,tags,
+// retaining only the keep most recent snapshots of every group while the snapshot files are read.
+func (r *Repository) SnapshotGroups(keep int) ([]snapshot.GroupData, error) {
+	return snapshot.GroupByHostAndTags(keep, func(add func(snapshot.Snapshot)) error {
+		return r.eachSnapshotFile(func(f snapshotFile) {
+			add(f.Snapshot)
+		})
+	})
+}
+
+// snapshotFiles loads all snapshot files sorted by time.
+func (r *Repository) snapshotFiles() ([]snapshotFile, error) {
+	var files []snapshotFile
+	err := r.eachSnapshotFile(func(f snapshotFile) {
+		files = append(files, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(files, func(a, b snapshotFile) int {
+		return a.Time.Compare(b.Time)
+	})
+	return files, nil
+}
+
+// eachSnapshotFile reads the snapshot files one after another in the order of their IDs and passes them to fn.
+func (r *Repository) eachSnapshotFile(fn func(snapshotFile)) error {
+	ids, err := r.SnapshotIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := r.SnapshotFile(id)
+		if err != nil {
+			return err
+		}
+		var f snapshotFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("parse snapshot %s: %w", id, err)
+		}
+		f.ID = id
+		f.ShortID = id[:8]
+		fn(f)
+	}
+	return nil
+}
+
+// Stats computes the statistics of restic stats --mode raw-data over all snapshots.
+func (r *Repository) Stats() (statistic.RawDataMetrics, error) {
+	files, err := r.snapshotFiles()
+	if err != nil {
+		return statistic.RawDataMetrics{}, err
+	}
+	index, err := r.loadIndex()
+	if err != nil {
+		return statistic.RawDataMetrics{}, err
+	}
+
+	blobs := map[blobHandle]bool{}
+	for _, f := range files {
+		if err := r.walkTree(index, f.Tree, blobs); err != nil {
+			return statistic.RawDataMetrics{}, fmt.Errorf("snapshot %s: %w", f.ShortID, err)
+		}
+	}
+
+	var totalSize, uncompressedSize, compressedSize, compressedUncompressedSize int
+	for handle := range blobs {
+		entry, ok := index[handle]
+		if !ok {
+			return statistic.RawDataMetrics{}, fmt.Errorf("blob %s not found in index", handle)
+		}
+		totalSize += entry.Length
+		if r.version >= 2 {
+			uncompressedSize += entry.dataLength() + overhead
+			if entry.UncompressedLength != 0 {
+				compressedSize += entry.Length
+				compressedUncompressedSize += entry.dataLength() + overhead
+			}
+		}
+	}
+
+	stats := statistic.RawDataMetrics{
+		TotalSize:             totalSize,
+		TotalUncompressedSize: uncompressedSize,
+		TotalBlobCount:        len(blobs),
+		SnapshotCount:         len(files),
+	}
+	if compressedSize > 0 {
+		stats.CompressionRatio = float64(compressedUncompressedSize) / float64(compressedSize)
+	}
+	if uncompressedSize > 0 {
+		stats.CompressionSpaceSaving = (1 - float64(totalSize)/float64(uncompressedSize)) * 100
+		stats.CompressionProgress = int(float64(compressedUncompressedSize) / float64(uncompressedSize) * 100)
+	}
+	return stats, nil
+}
+
+// readFile reads, verifies and decodes the file id in the directory dir of the repository.
+func (r *Repository) readFile(dir, id string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(r.path, dir, id))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("%s/%s: content does not match its ID", dir, id)
+	}
+
+	plaintext, err := r.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", dir, id, err)
+	}
+	return plaintext, nil
+}
+
+// decode decrypts data and decompresses it if it was compressed by repository format version 2.
+func (r *Repository) decode(data []byte) ([]byte, error) {
+	plaintext, err := r.key.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) == 0 || plaintext[0] == '{' || plaintext[0] == '[' {
+		return plaintext, nil
+	}
+	if plaintext[0] != 2 {
+		return nil, fmt.Errorf("unsupported encoding %d", plaintext[0])
+	}
+	return r.decoder.DecodeAll(plaintext[1:], nil)
+}
+
+// listFiles returns the names of the files in dir, which must exist.
+func listFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,216 @@
+package localrepo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"testing"
+)
+
+// testPassword opens the repositories in testdata, which were created by restic with testdata/create.sh.
+// restic calibrates the key derivation to about half a second, so every Open takes that long.
+const testPassword = "correct horse battery staple"
+
+// testdataRepository returns the path of the repository in the format version created by restic.
+func testdataRepository(version int) string {
+	return filepath.Join("testdata", fmt.Sprintf("v%d", version), "repository")
+}
+
+// copyRepository copies the repository in the format version to a temporary directory, so the test can modify it.
+func copyRepository(t *testing.T, version int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "repository")
+	if err := os.CopyFS(path, os.DirFS(testdataRepository(version))); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readResticOutput decodes the output of restic for the repository in the format version saved by testdata/create.sh.
+func readResticOutput(t *testing.T, version int, name string, v any) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d", version), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		password string
+		wantErr  error
+	}{
+		{name: "valid password", path: testdataRepository(2), password: testPassword},
+		{name: "second key", path: testdataRepository(2), password: "second password"},
+		{name: "wrong password", path: testdataRepository(2), password: "wrong", wantErr: ErrWrongPassword},
+		{name: "missing repository", path: filepath.Join(testdataRepository(2), "missing"), password: testPassword, wantErr: ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, err := Open(tt.path, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && repository.Version() != 2 {
+				t.Errorf("Version() = %d, want 2", repository.Version())
+			}
+		})
+	}
+}
+
+func TestOpen_Version1(t *testing.T) {
+	repository, err := Open(testdataRepository(1), testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repository.Version() != 1 {
+		t.Errorf("Version() = %d, want 1", repository.Version())
+	}
+}
+
+func TestRepository_Snapshots(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		var want []snapshot.Snapshot
+		readResticOutput(t, version, "snapshots.json", &want)
+
+		repository, err := Open(testdataRepository(version), testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := repository.Snapshots()
+		if err != nil {
+			t.Fatalf("version %d: Snapshots() error = %v", version, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("version %d: Snapshots() got = %+v, want %+v", version, got, want)
+		}
+	}
+}
+
+func TestRepository_SnapshotGroups(t *testing.T) {
+	repository, err := Open(testdataRepository(2), testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := repository.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := repository.SnapshotGroups(1)
+	if err != nil {
+		t.Fatalf("SnapshotGroups() error = %v", err)
+	}
+	got := map[string]snapshot.GroupData{}
+	for _, group := range groups {
+		got[group.GroupKey.Hostname] = group
+	}
+
+	// the snapshots are sorted by time, so the last one of SK12 is the most recent
+	want := map[string]snapshot.GroupData{
+		"DPC1": {GroupKey: snapshot.GroupKey{Hostname: "DPC1"}, Snapshots: snapshots[:1], Count: 1},
+		"SK12": {GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"daily", "kuma"}}, Snapshots: snapshots[2:], Count: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SnapshotGroups() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestRepository_Snapshots_Tampered(t *testing.T) {
+	path := copyRepository(t, 2)
+
+	ids, err := listFiles(filepath.Join(path, "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(path, "snapshots", ids[0])
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 1
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := Open(path, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repository.Snapshots(); err == nil {
+		t.Errorf("Snapshots() of a tampered repository did not fail")
+	}
+}
+
+func TestRepository_Stats(t *testing.T) {
+	// the repositories contain the data of a forgotten snapshot, which restic doesn't count
+	for _, version := range []int{1, 2} {
+		var want statistic.RawDataMetrics
+		readResticOutput(t, version, "stats.json", &want)
+
+		repository, err := Open(testdataRepository(version), testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := repository.Stats()
+		if err != nil {
+			t.Fatalf("version %d: Stats() error = %v", version, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("version %d: Stats() got = %+v, want %+v", version, got, want)
+		}
+	}
+}
+
+func TestRepository_loadIndex_Cached(t *testing.T) {
+	path := copyRepository(t, 2)
+
+	// hide an index file, so adding it back changes the index
+	ids, err := listFiles(filepath.Join(path, "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, hidden := filepath.Join(path, "index", ids[0]), filepath.Join(t.TempDir(), ids[0])
+	if err := os.Rename(file, hidden); err != nil {
+		t.Fatal(err)
+	}
+
+	repository, err := Open(path, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := repository.loadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repository.loadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.ValueOf(first).Pointer() != reflect.ValueOf(second).Pointer() {
+		t.Errorf("loadIndex() read the unchanged index again")
+	}
+
+	if err := os.Rename(hidden, file); err != nil {
+		t.Fatal(err)
+	}
+	third, err := repository.loadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third) <= len(first) {
+		t.Errorf("loadIndex() got %d blobs after adding an index, want more than %d", len(third), len(first))
+	}
+}
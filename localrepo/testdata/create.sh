@@ -0,0 +1,41 @@
+#!/bin/sh
+# Creates the repositories in v1 and v2 with restic, run it from this directory: ./create.sh /path/to/restic
+# Both repositories hold two snapshots of SK12 sharing a subtree, one of DPC1 and the unreferenced data of a forgotten snapshot.
+# snapshots.json and stats.json hold the output of restic for the repository, which the native reader has to reproduce.
+set -eu
+
+restic=${1:-restic}
+export RESTIC_PASSWORD="correct horse battery staple"
+# the backed up paths are stored in the snapshots, so they are the same on every run
+src=/tmp/restic-stats-exporter-fixture
+rm -rf "$src"
+trap 'rm -rf "$src"' EXIT
+
+mkdir -p "$src/srv/kuma/shared" "$src/home"
+echo "shared file" >"$src/srv/kuma/shared/file"
+echo "first file" >"$src/srv/kuma/file"
+
+for version in 1 2; do
+	repo=v$version/repository
+	rm -rf "v$version"
+	mkdir -p "v$version"
+	"$restic" init -q -r "$repo" --repository-version "$version"
+	printf 'second password\n' >"$src/second"
+	"$restic" key add -q -r "$repo" --new-password-file "$src/second" --host SK12 --user root
+
+	echo "first file" >"$src/srv/kuma/file"
+	"$restic" backup -q -r "$repo" --host SK12 --tag kuma,daily --time "2025-10-07 17:56:01" "$src/srv/kuma"
+	echo "second file" >"$src/srv/kuma/file"
+	"$restic" backup -q -r "$repo" --host SK12 --tag daily,kuma --time "2025-10-08 17:56:01" "$src/srv/kuma"
+	echo "home file" >"$src/home/file"
+	"$restic" backup -q -r "$repo" --host DPC1 --time "2025-10-07 12:00:00" "$src/home"
+
+	echo "unreferenced" >"$src/home/unreferenced"
+	id=$("$restic" backup -r "$repo" --json --host DPC1 --time "2025-10-07 13:00:00" "$src/home" |
+		sed -n 's/.*"snapshot_id":"\([0-9a-f]*\)".*/\1/p')
+	rm "$src/home/unreferenced"
+	"$restic" forget -q -r "$repo" "$id"
+
+	"$restic" snapshots -r "$repo" --json --no-lock >"v$version/snapshots.json"
+	"$restic" stats -r "$repo" --json --mode raw-data --no-lock >"v$version/stats.json"
+done
@@ -0,0 +1,113 @@
+// Package lock exports the locks of a restic repository, so stale locks blocking prune or check can be detected.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"restic-stats-exporter/util"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Lock is a lock file of the repository as printed by restic cat lock.
+type Lock struct {
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+}
+
+type Collector struct {
+	resticExecutablePath string
+	commandExecutor      util.CommandExecutor
+	parseErrors          prometheus.Counter
+	cache                util.Cache[result]
+}
+
+// result holds the outcome of listing the locks.
+type result struct {
+	exitCode int
+	locks    []Lock
+}
+
+func NewLockCollector(resticExecutablePath string, commandExecutor util.CommandExecutor, parseErrors prometheus.Counter) *Collector {
+	return &Collector{
+		resticExecutablePath: resticExecutablePath,
+		commandExecutor:      commandExecutor,
+		parseErrors:          parseErrors,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lockCountDesc
+	ch <- oldestLockTimeDesc
+	ch <- cacheAgeDesc
+	ch <- lockExitCode
+}
+
+// Run refreshes the locks every interval until ctx is cancelled, Collect serves the last result meanwhile.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	c.cache.Run(ctx, interval, c.refresh, nil)
+}
+
+// refresh lists the locks with restic and reads every lock file.
+func (c *Collector) refresh(ctx context.Context) (result, bool) {
+	out, err, exitCode := c.commandExecutor(ctx, c.resticExecutablePath, "list", "locks", "--no-lock")
+	if err != nil {
+		return result{exitCode: exitCode}, false
+	}
+
+	var locks []Lock
+	for _, id := range strings.Fields(string(out)) {
+		out, err, _ := c.commandExecutor(ctx, c.resticExecutablePath, "cat", "lock", id, "--no-lock")
+		if err != nil {
+			// the lock was released after it was listed
+			continue
+		}
+
+		var lock Lock
+		if err := json.Unmarshal(out, &lock); err != nil {
+			if c.parseErrors != nil {
+				c.parseErrors.Inc()
+			}
+			return result{exitCode: util.JSONParseErrorExitCode}, false
+		}
+		locks = append(locks, lock)
+	}
+
+	return result{exitCode: exitCode, locks: locks}, true
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	res, ok := c.cache.Current(context.Background(), c.refresh)
+	if !ok {
+		// the first refresh is still running
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(lockExitCode, prometheus.GaugeValue, float64(res.Value.exitCode))
+	if !res.OK {
+		return
+	}
+
+	if res.Cached {
+		ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(res.RefreshedAt).Seconds())
+	}
+
+	locks := res.Value.locks
+	ch <- prometheus.MustNewConstMetric(lockCountDesc, prometheus.GaugeValue, float64(len(locks)))
+	if len(locks) == 0 {
+		return
+	}
+
+	oldest := locks[0].Time
+	for _, lock := range locks[1:] {
+		if lock.Time.Before(oldest) {
+			oldest = lock.Time
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(oldestLockTimeDesc, prometheus.GaugeValue, float64(oldest.Unix()))
+}
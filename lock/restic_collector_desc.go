@@ -0,0 +1,28 @@
+package lock
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	lockCountDesc = prometheus.NewDesc(
+		"restic_lock_count",
+		"Number of locks in the repository",
+		nil, nil,
+	)
+
+	oldestLockTimeDesc = prometheus.NewDesc(
+		"restic_lock_oldest_time_seconds",
+		"Unix timestamp: creation or last refresh of the oldest lock in the repository, only exported if the repository is locked",
+		nil, nil,
+	)
+
+	cacheAgeDesc = prometheus.NewDesc(
+		"restic_lock_cache_age_seconds",
+		"Seconds since the served locks were refreshed, only exported when refreshing in the background",
+		nil, nil,
+	)
+
+	lockExitCode = prometheus.NewDesc("restic_lock_exit_code",
+		"Exit code of the list locks command. See restic exit codes, except 1684 for json output parsing errors: "+
+			"https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes",
+		nil, nil)
+)
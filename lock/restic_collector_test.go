@@ -0,0 +1,130 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_Collect(t *testing.T) {
+	lockFiles := map[string]string{
+		"a705e44e34346fa0503e913bb7781c6cf12d42572ad734cf22288ddd27d60753": `{"time":"2025-10-26T16:07:11.0Z","exclusive":false,"hostname":"DPC1","username":"root","pid":19158}`,
+		"0b6f3c2dd4fb1d6e1a8f4a5c2f1e4f4a9c0f3c1e2b5e7f0a6c2d1b3e4f5a6b7c": `{"time":"2025-10-26T15:41:02.0Z","exclusive":true,"hostname":"nas","username":"backup","pid":811}`,
+	}
+
+	tests := []struct {
+		name   string
+		listed string
+		want   string
+	}{
+		{
+			name:   "locked",
+			listed: "a705e44e34346fa0503e913bb7781c6cf12d42572ad734cf22288ddd27d60753\n0b6f3c2dd4fb1d6e1a8f4a5c2f1e4f4a9c0f3c1e2b5e7f0a6c2d1b3e4f5a6b7c\n",
+			want: `
+# HELP restic_lock_count Number of locks in the repository
+# TYPE restic_lock_count gauge
+restic_lock_count 2
+# HELP restic_lock_exit_code Exit code of the list locks command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_lock_exit_code gauge
+restic_lock_exit_code 0
+# HELP restic_lock_oldest_time_seconds Unix timestamp: creation or last refresh of the oldest lock in the repository, only exported if the repository is locked
+# TYPE restic_lock_oldest_time_seconds gauge
+restic_lock_oldest_time_seconds 1.761493262e+09
+`,
+		},
+		{
+			name:   "released after listing",
+			listed: "ffff000000000000000000000000000000000000000000000000000000000000\n",
+			want: `
+# HELP restic_lock_count Number of locks in the repository
+# TYPE restic_lock_count gauge
+restic_lock_count 0
+# HELP restic_lock_exit_code Exit code of the list locks command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_lock_exit_code gauge
+restic_lock_exit_code 0
+`,
+		},
+		{
+			name: "unlocked",
+			want: `
+# HELP restic_lock_count Number of locks in the repository
+# TYPE restic_lock_count gauge
+restic_lock_count 0
+# HELP restic_lock_exit_code Exit code of the list locks command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_lock_exit_code gauge
+restic_lock_exit_code 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				switch {
+				case strings.Join(args, " ") == "list locks --no-lock":
+					return []byte(tt.listed), nil, 0
+				case len(args) == 4 && args[0] == "cat" && args[1] == "lock" && args[3] == "--no-lock":
+					if lock, ok := lockFiles[args[2]]; ok {
+						return []byte(lock), nil, 0
+					}
+					return nil, errors.New("no such file or directory"), 1
+				}
+				t.Fatalf("unexpected arguments: %v", args)
+				return nil, nil, 0
+			}
+
+			c := NewLockCollector("restic", fakeExec, nil)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want)); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		exec           func(_ context.Context, exe string, args ...string) ([]byte, error, int)
+		wantExitCode   string
+		wantParseError float64
+	}{
+		{
+			name: "list fails",
+			exec: func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				return nil, errors.New("error for unit test"), 12
+			},
+			wantExitCode: "12",
+		},
+		{
+			name: "invalid lock file",
+			exec: func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				if args[0] == "list" {
+					return []byte("a705e44e\n"), nil, 0
+				}
+				return []byte(`{{`), nil, 0
+			},
+			wantExitCode:   "1684",
+			wantParseError: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parseErrors := prometheus.NewCounter(prometheus.CounterOpts{Name: "parse_errors"})
+			c := NewLockCollector("restic", tt.exec, parseErrors)
+
+			want := `
+# HELP restic_lock_exit_code Exit code of the list locks command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_lock_exit_code gauge
+restic_lock_exit_code ` + tt.wantExitCode + "\n"
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+			if got := testutil.ToFloat64(parseErrors); got != tt.wantParseError {
+				t.Errorf("parse errors = %v, want %v", got, tt.wantParseError)
+			}
+		})
+	}
+}
@@ -1,45 +1,585 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"restic-stats-exporter/api"
+	"restic-stats-exporter/check"
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/dashboard"
+	"restic-stats-exporter/grafana"
+	"restic-stats-exporter/localrepo"
+	"restic-stats-exporter/lock"
+	"restic-stats-exporter/notify"
+	"restic-stats-exporter/otlp"
+	"restic-stats-exporter/relabel"
+	"restic-stats-exporter/restserver"
 	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/state"
+	"restic-stats-exporter/statistic"
+	"restic-stats-exporter/status"
+	"restic-stats-exporter/util"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 func main() {
-	slog.Info("Starting restic statistics exporter...")
-	checkEnv("RESTIC_REPOSITORY")
+	slog.Info("Starting restic statistics exporter...", "version", version.Info())
+	configFile := getEnvWithDefault("RSE_CONFIG_FILE", "")
 
-	resticExecutablePath := getEnvWithDefault("RSE_RESTIC_EXECUTABLE_PATH", "restic")
+	mode := "serve"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+
+	switch mode {
+	case "serve":
+		runServe(loadConfig(configFile))
+	case "push":
+		runPush(loadConfig(configFile))
+	case "textfile":
+		runTextfile(loadConfig(configFile))
+	case "rules":
+		runRules(loadConfig(configFile))
+	case "dashboard":
+		runDashboard()
+	default:
+		slog.Error("Unknown mode", "mode", mode)
+		os.Exit(2)
+	}
+}
+
+// runServe exposes the metrics of all repositories on the HTTP server.
+func runServe(cfg config.Config) {
+	webConfigFile := getEnvWithDefault("RSE_WEB_CONFIG_FILE", "")
+	refreshInterval := getDurationEnvWithDefault("RSE_REFRESH_INTERVAL", 0)
+	otlpProtocol := getEnvWithDefault("RSE_OTLP_PROTOCOL", "")
+	notifyInterval := getDurationEnvWithDefault("RSE_NOTIFY_INTERVAL", time.Minute)
+	stateFile := getEnvWithDefault("RSE_STATE_FILE", "")
+
+	if err := web.Validate(webConfigFile); err != nil {
+		slog.Error("Invalid web config file", "path", webConfigFile, "error", err)
+		os.Exit(1)
+	}
 
-	prometheus.MustRegister(snapshot.NewSnapshotCollector(resticExecutablePath))
+	prometheus.MustRegister(versioncollector.NewCollector("restic_exporter"))
 
-	addr := ":2112"
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	statusRegistry := status.NewRegistry()
+	// restic stats reads the whole index, so it only runs in the background and never on a scrape
+	repositories := registerRepositories(cfg, prometheus.DefaultRegisterer, statusRegistry, refreshInterval > 0, refreshInterval == 0)
+
+	// workers holds the goroutines updating the results, the state file is written for the last time after they stopped
+	var workers sync.WaitGroup
+	var stateSaved <-chan struct{}
+	if stateFile != "" {
+		stateCtx, stopState := context.WithCancel(context.Background())
+		defer stopState()
+		stateSaved = startStatePersistence(stateCtx, stateFile, repositories)
+		go func() {
+			<-ctx.Done()
+			workers.Wait()
+			stopState()
+		}()
+	}
+	for _, collectors := range repositories {
+		if refreshInterval > 0 {
+			slog.Info("Refreshing repository in background", "repository", collectors.repository, "interval", refreshInterval)
+			workers.Go(func() { collectors.snapshot.Run(ctx, refreshInterval) })
+			workers.Go(func() { collectors.statistic.Run(ctx, refreshInterval) })
+			if collectors.restServer != nil {
+				workers.Go(func() { collectors.restServer.Run(ctx, refreshInterval) })
+			}
+			if collectors.lock != nil {
+				workers.Go(func() { collectors.lock.Run(ctx, refreshInterval) })
+			}
+		}
+		if collectors.check != nil {
+			// restic check is never run on a scrape
+			slog.Info("Checking repository in background", "repository", collectors.repository, "interval", collectors.repository.CheckPeriod())
+			workers.Go(func() { collectors.check.Run(ctx, collectors.repository.CheckPeriod()) })
+		}
+	}
+
+	var otlpExporter *otlp.Exporter
+	if otlpProtocol != "" {
+		// every export gathers the metrics, which would run restic without the cached results of the background refresh
+		if refreshInterval == 0 {
+			slog.Error("OTLP export requires background refresh", "name", "RSE_REFRESH_INTERVAL")
+			os.Exit(1)
+		}
+		otlpExporter = startOTLPExporter(otlpProtocol, repositories)
+	}
+
+	if webhookURL := getEnvWithDefault("RSE_WEBHOOK_URL", ""); webhookURL != "" {
+		webhook := config.Webhook{URL: webhookURL, Format: getEnvWithDefault("RSE_WEBHOOK_FORMAT", "")}
+		if err := webhook.Validate(); err != nil {
+			slog.Error("Invalid webhook", "name", "RSE_WEBHOOK_URL", "error", err)
+			os.Exit(1)
+		}
+		cfg.Webhooks = append(cfg.Webhooks, webhook)
+	}
+	if len(cfg.Webhooks) > 0 {
+		startNotifications(ctx, &workers, cfg.Webhooks, notifyInterval, repositories, statusRegistry)
+	}
+
+	addr := getEnvWithDefault("RSE_LISTEN_ADDRESS", ":2112")
 	slog.Info("Starting metrics HTTP server", "addr", addr)
 
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/-/healthy", status.HealthyHandler())
+	http.Handle("/-/ready", status.ReadyHandler(statusRegistry))
+	http.Handle("/status", status.Handler(statusRegistry))
+	http.Handle("/api/v1/", api.NewHandler(apiRepositories(repositories)))
+	http.Handle("/dashboard.json", grafana.Handler())
+	http.Handle("/", dashboard.NewHandler(dashboardRepositories(repositories, statusRegistry)))
+
+	server := &http.Server{}
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{addr},
+		WebSystemdSocket:   new(bool),
+		WebConfigFile:      &webConfigFile,
+	}
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		slog.Info("Shutting down")
+		shutdownServer(server)
+		if otlpExporter != nil {
+			shutdownOTLPExporter(otlpExporter)
+		}
+	}()
+
+	if err := web.ListenAndServe(server, flags, slog.Default()); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("HTTP server failed", "error", err)
 		os.Exit(1)
 	}
+	<-shutdown
+	if stateSaved != nil {
+		<-stateSaved
+	}
 }
 
-func checkEnv(name string) {
-	val, ok := os.LookupEnv(name)
-	if !ok {
-		slog.Error("Environment variable is not set", "name", name)
+// shutdownTimeout is the time the HTTP server and the OTLP exporter get to finish on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// shutdownServer stops server after the running requests finished or shutdownTimeout passed.
+func shutdownServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+// shutdownOTLPExporter exports the metrics a last time and stops exporter.
+func shutdownOTLPExporter(exporter *otlp.Exporter) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := exporter.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down OTLP exporter", "error", err)
+	}
+}
+
+// startOTLPExporter exports the cached snapshot and statistic metrics of all repositories via OTLP in addition to serving them.
+func startOTLPExporter(protocol string, repositories []repositoryCollectors) *otlp.Exporter {
+	otlpRepositories := make([]otlp.Repository, 0, len(repositories))
+	for _, collectors := range repositories {
+		if collectors.label == "" {
+			continue
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.snapshot, collectors.statistic)
+		otlpRepositories = append(otlpRepositories, otlp.Repository{Name: collectors.label, Gatherer: registry})
+	}
+
+	exporter, err := otlp.New(context.Background(), protocol, otlpRepositories)
+	if err != nil {
+		slog.Error("Failed to start OTLP exporter", "error", err)
 		os.Exit(1)
 	}
+	slog.Info("Exporting metrics via OTLP", "protocol", protocol)
+	return exporter
+}
+
+// startStatePersistence restores the last successful results of the repositories from the state file at path
+// and keeps it up to date until ctx is cancelled. The returned channel is closed after the last write.
+func startStatePersistence(ctx context.Context, path string, repositories []repositoryCollectors) <-chan struct{} {
+	sources := make([]state.Source, 0, len(repositories))
+	for _, collectors := range repositories {
+		source := state.Source{
+			Name:       collectors.repository.Name,
+			Snapshots:  collectors.snapshot,
+			Statistics: collectors.statistic,
+		}
+		if collectors.check != nil {
+			// a nil *check.Collector would be a non-nil CheckStore
+			source.Check = collectors.check
+		}
+		sources = append(sources, source)
+	}
+
+	f, err := state.Load(path)
+	if err != nil {
+		slog.Error("Failed to load state file", "path", path, "error", err)
+		os.Exit(1)
+	}
+	f.Restore(sources)
+	slog.Info("Restored state", "path", path, "repositories", len(f.Repositories))
+
+	saved := make(chan struct{})
+	go func() {
+		state.Run(ctx, path, time.Minute, sources)
+		close(saved)
+	}()
+	return saved
+}
 
-	if val == "" {
-		slog.Error("Environment variable is empty", "name", name)
+// startNotifications checks the repositories for state changes every interval and sends them to webhooks until ctx is cancelled.
+// The watcher is added to workers.
+func startNotifications(ctx context.Context, workers *sync.WaitGroup, webhooks []config.Webhook, interval time.Duration, repositories []repositoryCollectors, statusRegistry *status.Registry) {
+	notifier, err := notify.NewNotifier(webhooks)
+	if err != nil {
+		slog.Error("Invalid webhook", "error", err)
 		os.Exit(1)
 	}
+
+	notifyRepositories := make([]notify.Repository, 0, len(repositories))
+	for _, collectors := range repositories {
+		repository := notify.Repository{
+			Config:    collectors.repository,
+			Snapshots: collectors.snapshot,
+			Status:    statusRegistry.Get(collectors.repository.Name),
+		}
+		if collectors.check != nil {
+			// a nil *check.Collector would be a non-nil CheckSource
+			repository.Check = collectors.check
+		}
+		notifyRepositories = append(notifyRepositories, repository)
+	}
+
+	watcher := notify.NewWatcher(notifyRepositories)
+	workers.Go(func() { watcher.Run(ctx, interval, notifier) })
+	slog.Info("Sending notifications to webhooks", "webhooks", len(webhooks), "interval", interval)
+}
+
+// apiRepositories returns the repositories served by the JSON API.
+func apiRepositories(repositories []repositoryCollectors) []api.Repository {
+	apiRepositories := make([]api.Repository, 0, len(repositories))
+	for _, collectors := range repositories {
+		apiRepositories = append(apiRepositories, api.Repository{
+			Name:       collectors.repository.Name,
+			Snapshots:  collectors.snapshot,
+			Statistics: collectors.statistic,
+		})
+	}
+	return apiRepositories
+}
+
+// dashboardRepositories returns the repositories shown on the HTML dashboard.
+func dashboardRepositories(repositories []repositoryCollectors, statusRegistry *status.Registry) []dashboard.Repository {
+	dashboardRepositories := make([]dashboard.Repository, 0, len(repositories))
+	for _, collectors := range repositories {
+		groupStaleAfter := map[string]time.Duration{}
+		for _, group := range collectors.repository.Groups {
+			groupStaleAfter[group.String()] = collectors.repository.GroupStaleThreshold(group)
+		}
+		dashboardRepositories = append(dashboardRepositories, dashboard.Repository{
+			Name:            collectors.repository.Name,
+			StaleAfter:      collectors.repository.StaleThreshold(),
+			GroupStaleAfter: groupStaleAfter,
+			Snapshots:       collectors.snapshot,
+			Status:          statusRegistry.Get(collectors.repository.Name),
+		})
+	}
+	return dashboardRepositories
+}
+
+// repositoryCollectors holds the collectors of a single repository.
+type repositoryCollectors struct {
+	repository config.Repository
+	// label is the repository label of the exported metrics, empty if relabel rules drop them.
+	label     string
+	snapshot  *snapshot.Collector
+	statistic *statistic.Collector
+	// restServer, lock and check are nil unless enabled for the repository.
+	restServer *restserver.Collector
+	lock       *lock.Collector
+	check      *check.Collector
+}
+
+// registerRepositories creates the collectors of all configured repositories and registers them
+// with registerer, labelled with the repository name. The statistic collectors are only registered if statistics is set.
+// If scrapes is set, restic runs on scrapes and the command timeout includes the time waiting for a slot,
+// so commands of a scrape Prometheus already gave up on don't keep waiting.
+func registerRepositories(cfg config.Config, registerer prometheus.Registerer, statusRegistry *status.Registry, statistics, scrapes bool) []repositoryCollectors {
+	resticExecutablePath := getEnvWithDefault("RSE_RESTIC_EXECUTABLE_PATH", "restic")
+	globalLimiter := util.NewLimiter(getIntEnvWithDefault("RSE_MAX_CONCURRENT_COMMANDS", 0))
+	commandTimeout := getDurationEnvWithDefault("RSE_COMMAND_TIMEOUT", 0)
+	restServerTimeout := getDurationEnvWithDefault("RSE_REST_SERVER_TIMEOUT", 30*time.Second)
+
+	relabelRules, err := relabel.New(cfg.Relabel)
+	if err != nil {
+		slog.Error("Invalid relabel rules", "error", err)
+		os.Exit(1)
+	}
+
+	collectors := make([]repositoryCollectors, 0, len(cfg.Repositories))
+	labels := map[string]string{}
+	for _, repository := range cfg.Repositories {
+		label, ok := relabelRules.Repository(repository.Name)
+		if other, exists := labels[label]; ok && exists {
+			slog.Error("Relabel rules map repositories to the same name", "repository", repository, "other", other, "name", label)
+			os.Exit(1)
+		}
+
+		var repositoryRegisterer prometheus.Registerer
+		if ok {
+			labels[label] = repository.Name
+			repositoryRegisterer = prometheus.WrapRegistererWith(prometheus.Labels{"repository": label}, registerer)
+		} else {
+			slog.Info("Relabel rules drop the metrics of repository", "repository", repository)
+			// the collectors still serve the API and the notifications
+			label, repositoryRegisterer = "", prometheus.NewRegistry()
+		}
+
+		commandMetrics := util.NewCommandMetrics()
+		commandMetrics.MustRegister(repositoryRegisterer)
+		commandExecutor := newCommandExecutor(repository, repositoryRegisterer, commandMetrics, globalLimiter, commandTimeout, scrapes)
+
+		secrets, err := repository.Secrets()
+		if err != nil {
+			slog.Error("Failed to read repository secrets", "repository", repository, "error", err)
+			os.Exit(1)
+		}
+		repositoryStatus := statusRegistry.Add(repository.Name)
+		repositoryStatus.Redact(secrets...)
+
+		snapshotCollector := snapshot.NewSnapshotCollector(
+			resticExecutablePath,
+			commandExecutor,
+			repositoryStatus,
+			commandMetrics.ParseErrors.WithLabelValues("snapshots"),
+		)
+		if repository.Incremental {
+			snapshotCollector.LoadIncrementally()
+		}
+		if repository.SortTags {
+			snapshotCollector.SortTags()
+		}
+		if repository.TagSeries {
+			snapshotCollector.ExportTagSeries()
+		}
+		if len(cfg.Relabel) > 0 {
+			snapshotCollector.Relabel(relabelRules.Group)
+		}
+		if repository.MaxGroups > 0 {
+			snapshotCollector.LimitGroups(repository.MaxGroups)
+		}
+		snapshotCollector.DetectAnomalies(snapshot.AnomalyThresholds{
+			Window:   repository.AnomalyWindow(),
+			MinRatio: repository.AnomalyMinRatio(),
+			MaxRatio: repository.Anomaly.MaxRatio,
+		})
+		for _, group := range repository.Groups {
+			if len(group.Paths) > 0 {
+				snapshotCollector.ExpectScope(group.Hostname, group.Tags, snapshot.NewScope(group.Paths, group.Excludes))
+			}
+		}
+		statisticCollector := statistic.NewStatisticCollector(
+			resticExecutablePath,
+			commandExecutor.Output(),
+			commandMetrics.ParseErrors.WithLabelValues("stats"),
+		)
+		repositoryRegisterer.MustRegister(snapshotCollector)
+		if statistics {
+			repositoryRegisterer.MustRegister(statisticCollector)
+		}
+
+		var restServerCollector *restserver.Collector
+		if repository.RestServer {
+			restServerCollector = newRestServerCollector(repository, restServerTimeout)
+			repositoryRegisterer.MustRegister(restServerCollector)
+		}
+
+		var lockCollector *lock.Collector
+		if repository.Locks {
+			lockCollector = lock.NewLockCollector(resticExecutablePath, commandExecutor.Output(), commandMetrics.ParseErrors.WithLabelValues("cat"))
+			repositoryRegisterer.MustRegister(lockCollector)
+		}
+
+		var checkCollector *check.Collector
+		if repository.Check {
+			checkCollector = check.NewCheckCollector(resticExecutablePath, newCheckExecutor(repository, commandMetrics, commandTimeout), commandMetrics.ParseErrors.WithLabelValues("check"))
+			repositoryRegisterer.MustRegister(checkCollector)
+		}
+
+		collectors = append(collectors, repositoryCollectors{
+			repository: repository,
+			label:      label,
+			snapshot:   snapshotCollector,
+			statistic:  statisticCollector,
+			restServer: restServerCollector,
+			lock:       lockCollector,
+			check:      checkCollector,
+		})
+	}
+
+	return collectors
+}
+
+// newRestServerCollector returns the collector querying rest-server for the repository.
+// Credentials are taken from the repository URL or the RESTIC_REST_USERNAME and RESTIC_REST_PASSWORD variables of the repository.
+func newRestServerCollector(repository config.Repository, timeout time.Duration) *restserver.Collector {
+	env, err := repository.Environ(nil)
+	if err != nil {
+		slog.Error("Failed to build restic environment", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+	location, err := repository.Location()
+	if err != nil {
+		slog.Error("Failed to read rest-server repository location", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+
+	var username, password string
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		switch name {
+		case "RESTIC_REST_USERNAME":
+			username = value
+		case "RESTIC_REST_PASSWORD":
+			password = value
+		}
+	}
+
+	collector, err := restserver.NewCollector(location, username, password, &http.Client{Timeout: timeout})
+	if err != nil {
+		slog.Error("Invalid rest-server repository", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+	if repository.AppendOnly != nil {
+		collector.ReportAppendOnly(*repository.AppendOnly)
+	}
+	return collector
+}
+
+// newOneShotRegistry returns a registry with the collectors of all repositories for modes
+// that gather the metrics without serving them, so the Go runtime metrics of the short-lived process are left out.
+func newOneShotRegistry(cfg config.Config) (*prometheus.Registry, []repositoryCollectors) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(versioncollector.NewCollector("restic_exporter"))
+	repositories := registerRepositories(cfg, registry, status.NewRegistry(), true, false)
+	return registry, repositories
+}
+
+// staticGatherer returns a gatherer that always returns families, so already gathered metrics
+// can be written without invoking restic again.
+func staticGatherer(families []*dto.MetricFamily) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+}
+
+// newCommandExecutor returns the executor for restic commands against the repository.
+// Commands run with the repository environment or are answered by the native reader if configured, are instrumented with commandMetrics, are limited by
+// the repository and the global limiter, are killed after timeout and identical concurrent commands share a single execution.
+// If boundWait is set, the timeout includes the time waiting for a slot.
+func newCommandExecutor(repository config.Repository, registerer prometheus.Registerer, commandMetrics *util.CommandMetrics, globalLimiter *util.Limiter, timeout time.Duration, boundWait bool) util.StreamCommandExecutor {
+	env, err := repository.Environ(os.Environ())
+	if err != nil {
+		slog.Error("Failed to build restic environment", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restic_exporter_commands_queued",
+		Help: "Number of restic commands waiting for a free execution slot",
+	})
+	running := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restic_exporter_commands_running",
+		Help: "Number of currently running restic commands",
+	})
+	registerer.MustRegister(queued, running)
+
+	executor := util.NewEnvStreamCommandExecutor(env, timeout)
+	if repository.Native {
+		executor = newNativeCommandExecutor(repository, env)
+	}
+
+	limited := util.LimitedCommandExecutor(
+		util.InstrumentedCommandExecutor(executor, commandMetrics),
+		queued,
+		running,
+		util.NewLimiter(repository.CommandLimit()),
+		globalLimiter,
+	)
+	if boundWait {
+		limited = util.TimeoutCommandExecutor(limited, timeout)
+	}
+	return util.SingleflightCommandExecutor(limited)
+}
+
+// newCheckExecutor returns the executor for restic check against the repository. restic check can run for hours,
+// so it doesn't take a slot of the repository or the global limiter and other commands don't wait for it.
+func newCheckExecutor(repository config.Repository, commandMetrics *util.CommandMetrics, timeout time.Duration) util.CommandExecutor {
+	env, err := repository.Environ(os.Environ())
+	if err != nil {
+		slog.Error("Failed to build restic environment", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+	return util.InstrumentedCommandExecutor(util.NewEnvStreamCommandExecutor(env, timeout), commandMetrics).Output()
+}
+
+// newNativeCommandExecutor returns an executor that reads the local repository directly instead of running restic.
+func newNativeCommandExecutor(repository config.Repository, env []string) util.StreamCommandExecutor {
+	path, err := repository.LocalPath()
+	if err != nil {
+		slog.Error("Failed to read native repository location", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+	password, err := repository.ReadPassword(env)
+	if err != nil {
+		slog.Error("Failed to read native repository password", "repository", repository, "error", err)
+		os.Exit(1)
+	}
+	return localrepo.NewCommandExecutor(path, password)
+}
+
+// loadConfig reads the repositories from the config file at path or, if path is empty, from the restic environment variables.
+func loadConfig(path string) config.Config {
+	cfg := config.FromEnv()
+	if path != "" {
+		var err error
+		cfg, err = config.Load(path)
+		if err != nil {
+			slog.Error("Failed to load config file", "path", path, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	return cfg
 }
 
 func getEnvWithDefault(name string, defaultValue string) string {
@@ -50,3 +590,33 @@ func getEnvWithDefault(name string, defaultValue string) string {
 
 	return val
 }
+
+func getDurationEnvWithDefault(name string, defaultValue time.Duration) time.Duration {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Error("Environment variable is not a valid duration", "name", name, "value", val, "error", err)
+		os.Exit(1)
+	}
+
+	return duration
+}
+
+func getIntEnvWithDefault(name string, defaultValue int) int {
+	val, ok := os.LookupEnv(name)
+	if !ok || val == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		slog.Error("Environment variable is not a valid integer", "name", name, "value", val, "error", err)
+		os.Exit(1)
+	}
+
+	return i
+}
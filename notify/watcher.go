@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EventBackupStale is sent when the last snapshot of a group becomes older than its stale threshold.
+	EventBackupStale = "backup_stale"
+	// EventSnapshotNew is sent when a new snapshot of a group appears.
+	EventSnapshotNew = "snapshot_new"
+	// EventCollectionFailed is sent when restic starts failing for a repository.
+	EventCollectionFailed = "collection_failed"
+	// EventCollectionRecovered is sent when restic succeeds again after a failure.
+	EventCollectionRecovered = "collection_recovered"
+	// EventCheckFailed is sent when restic check finds errors in a repository.
+	EventCheckFailed = "check_failed"
+)
+
+// eventTypes lists all known event types.
+var eventTypes = []string{EventBackupStale, EventSnapshotNew, EventCollectionFailed, EventCollectionRecovered, EventCheckFailed}
+
+// Event describes a state change of a repository or one of its snapshot groups.
+type Event struct {
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+}
+
+// Title returns a short human-readable summary of the event.
+func (e Event) Title() string {
+	subject := e.Repository
+	if e.Hostname != "" {
+		subject += " " + e.Hostname
+		if len(e.Tags) > 0 {
+			subject += " [" + strings.Join(e.Tags, ",") + "]"
+		}
+	}
+
+	switch e.Type {
+	case EventBackupStale:
+		return "restic backup stale: " + subject
+	case EventSnapshotNew:
+		return "restic snapshot created: " + subject
+	case EventCollectionFailed:
+		return "restic failing: " + subject
+	case EventCollectionRecovered:
+		return "restic recovered: " + subject
+	case EventCheckFailed:
+		return "restic check failed: " + subject
+	}
+	return e.Type + ": " + subject
+}
+
+// SnapshotSource provides the snapshot groups of the last successful refresh of a repository.
+type SnapshotSource interface {
+	GroupData() ([]snapshot.GroupData, time.Time, bool)
+}
+
+// CheckSource provides the result of the last finished restic check of a repository.
+type CheckSource interface {
+	LastCheck() (numErrors int, checkedAt time.Time, ok bool)
+}
+
+// Repository is a repository watched for state changes.
+type Repository struct {
+	Config    config.Repository
+	Snapshots SnapshotSource
+	Status    *status.Repository
+	// Check is nil unless check is enabled for the repository.
+	Check CheckSource
+}
+
+// Watcher detects state changes of repositories between two checks.
+type Watcher struct {
+	repositories []Repository
+	states       []repositoryState
+	now          func() time.Time
+}
+
+type repositoryState struct {
+	initialized bool
+	failed      bool
+	checkedAt   time.Time
+	groups      map[string]groupState
+}
+
+type groupState struct {
+	lastSnapshot time.Time
+	stale        bool
+}
+
+func NewWatcher(repositories []Repository) *Watcher {
+	states := make([]repositoryState, len(repositories))
+	for i := range states {
+		states[i].groups = map[string]groupState{}
+	}
+	return &Watcher{repositories: repositories, states: states, now: time.Now}
+}
+
+// Run checks the repositories every interval until ctx is cancelled and sends the detected events with notifier.
+// The events are delivered in the background, so failing webhooks don't delay the next check. Run returns after the delivery stopped.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, notifier *Notifier) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	wg.Go(func() { notifier.Run(ctx) })
+	defer wg.Wait()
+
+	for {
+		for _, event := range w.Check() {
+			notifier.Enqueue(event)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check returns the state changes since the previous check.
+// On the first check failing repositories and stale groups are reported, existing snapshots are not.
+func (w *Watcher) Check() []Event {
+	now := w.now()
+
+	var events []Event
+	for i, repository := range w.repositories {
+		events = append(events, w.checkStatus(repository, &w.states[i], now)...)
+		events = append(events, w.checkCheck(repository, &w.states[i], now)...)
+		events = append(events, w.checkSnapshots(repository, &w.states[i], now)...)
+	}
+	return events
+}
+
+func (w *Watcher) checkStatus(repository Repository, state *repositoryState, now time.Time) []Event {
+	if repository.Status == nil {
+		return nil
+	}
+
+	s := repository.Status.Status()
+	if s.LastRefresh.IsZero() {
+		return nil
+	}
+
+	failed := s.ExitCode != 0
+	defer func() { state.failed = failed }()
+
+	switch {
+	case failed && !state.failed:
+		message := fmt.Sprintf("restic exited with exit code %d", s.ExitCode)
+		if s.Stderr != "" {
+			message += ": " + s.Stderr
+		}
+		return []Event{{Type: EventCollectionFailed, Repository: repository.Config.Name, Time: now, Message: message}}
+	case !failed && state.failed:
+		return []Event{{Type: EventCollectionRecovered, Repository: repository.Config.Name, Time: now, Message: "restic succeeded again"}}
+	}
+	return nil
+}
+
+// checkCheck reports every finished restic check that found errors.
+func (w *Watcher) checkCheck(repository Repository, state *repositoryState, now time.Time) []Event {
+	if repository.Check == nil {
+		return nil
+	}
+
+	numErrors, checkedAt, ok := repository.Check.LastCheck()
+	if !ok || !checkedAt.After(state.checkedAt) {
+		return nil
+	}
+	state.checkedAt = checkedAt
+
+	if numErrors == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("restic check started at %s found %d errors", checkedAt.Format(time.RFC3339), numErrors)
+	return []Event{{Type: EventCheckFailed, Repository: repository.Config.Name, Time: now, Message: message}}
+}
+
+func (w *Watcher) checkSnapshots(repository Repository, state *repositoryState, now time.Time) []Event {
+	groupData, _, ok := repository.Snapshots.GroupData()
+	if !ok {
+		return nil
+	}
+
+	var events []Event
+	for _, group := range groupData {
+		if len(group.Snapshots) == 0 {
+			continue
+		}
+
+		last := group.Snapshots[0].Time
+		for _, s := range group.Snapshots {
+			if s.Time.After(last) {
+				last = s.Time
+			}
+		}
+
+		hostname, tags := group.GroupKey.Hostname, group.GroupKey.Tags
+		configured := repository.Config.FindGroup(hostname, tags)
+		threshold := repository.Config.GroupStaleThreshold(configured)
+		stale := now.Sub(last) > threshold
+
+		key := configured.String()
+		previous, known := state.groups[key]
+		state.groups[key] = groupState{lastSnapshot: last, stale: stale}
+
+		event := Event{Repository: repository.Config.Name, Hostname: hostname, Tags: tags, Time: now}
+		if (known && last.After(previous.lastSnapshot)) || (!known && state.initialized) {
+			event.Type = EventSnapshotNew
+			event.Message = fmt.Sprintf("New snapshot created at %s", last.Format(time.RFC3339))
+			events = append(events, event)
+		}
+		if stale && !previous.stale {
+			event.Type = EventBackupStale
+			event.Message = fmt.Sprintf("Last snapshot created at %s, expected at most every %s", last.Format(time.RFC3339), threshold)
+			events = append(events, event)
+		}
+	}
+
+	state.initialized = true
+	return events
+}
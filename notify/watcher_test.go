@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/status"
+	"testing"
+	"time"
+)
+
+type fakeSnapshotSource struct {
+	groupData []snapshot.GroupData
+	ok        bool
+}
+
+func (f *fakeSnapshotSource) GroupData() ([]snapshot.GroupData, time.Time, bool) {
+	return f.groupData, time.Time{}, f.ok
+}
+
+func group(hostname string, tags []string, times ...time.Time) snapshot.GroupData {
+	g := snapshot.GroupData{GroupKey: snapshot.GroupKey{Hostname: hostname, Tags: tags}}
+	for _, t := range times {
+		g.Snapshots = append(g.Snapshots, snapshot.Snapshot{Time: t, Hostname: hostname, Tags: tags})
+	}
+	return g
+}
+
+func eventTypesOf(events []Event) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type+" "+e.Hostname)
+	}
+	return types
+}
+
+func assertEvents(t *testing.T, events []Event, want ...string) {
+	t.Helper()
+
+	got := eventTypesOf(events)
+	if len(got) != len(want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWatcher_Check(t *testing.T) {
+	start := time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC)
+	now := start
+	source := &fakeSnapshotSource{ok: true, groupData: []snapshot.GroupData{
+		group("SK12", []string{"kuma"}, start.Add(-2*time.Hour)),
+		group("DPC1", []string{"daily"}, start.Add(-20*time.Hour)),
+	}}
+	repositoryStatus := status.NewRegistry().Add("local")
+	repositoryStatus.Record(now, time.Second, 0, "")
+
+	watcher := NewWatcher([]Repository{{
+		Config: config.Repository{
+			Name:   "local",
+			Groups: []config.Group{{Hostname: "DPC1", Tags: []string{"daily"}, StaleAfter: 48 * time.Hour}},
+		},
+		Snapshots: source,
+		Status:    repositoryStatus,
+	}})
+	watcher.now = func() time.Time { return now }
+
+	// existing snapshots are not reported on the first check
+	assertEvents(t, watcher.Check())
+
+	// nothing changed
+	assertEvents(t, watcher.Check())
+
+	// SK12 exceeds the default threshold of 24h, DPC1 is still within its 48h
+	now = now.Add(23 * time.Hour)
+	assertEvents(t, watcher.Check(), EventBackupStale+" SK12")
+	assertEvents(t, watcher.Check())
+
+	// a new snapshot of SK12 and a new group, DPC1 exceeds its 48h
+	now = now.Add(6 * time.Hour)
+	source.groupData = []snapshot.GroupData{
+		group("SK12", []string{"kuma"}, start.Add(-2*time.Hour), now.Add(-time.Minute)),
+		group("DPC1", []string{"daily"}, start.Add(-20*time.Hour)),
+		group("NAS", nil, now.Add(-time.Minute)),
+	}
+	assertEvents(t, watcher.Check(), EventSnapshotNew+" SK12", EventBackupStale+" DPC1", EventSnapshotNew+" NAS")
+
+	// restic starts failing and recovers
+	repositoryStatus.Record(now, time.Second, 12, "Fatal: wrong password or no key found")
+	events := watcher.Check()
+	assertEvents(t, events, EventCollectionFailed+" ")
+	if want := "restic exited with exit code 12: Fatal: wrong password or no key found"; events[0].Message != want {
+		t.Errorf("Message = %q, want %q", events[0].Message, want)
+	}
+	assertEvents(t, watcher.Check())
+
+	repositoryStatus.Record(now, time.Second, 0, "")
+	assertEvents(t, watcher.Check(), EventCollectionRecovered+" ")
+}
+
+func TestWatcher_Check_InitiallyBroken(t *testing.T) {
+	now := time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC)
+	repositoryStatus := status.NewRegistry().Add("local")
+	repositoryStatus.Record(now, time.Second, 1, "")
+
+	watcher := NewWatcher([]Repository{{
+		Config: config.Repository{Name: "local"},
+		Snapshots: &fakeSnapshotSource{ok: true, groupData: []snapshot.GroupData{
+			group("SK12", []string{"kuma"}, now.Add(-48*time.Hour)),
+		}},
+		Status: repositoryStatus,
+	}})
+	watcher.now = func() time.Time { return now }
+
+	assertEvents(t, watcher.Check(), EventCollectionFailed+" ", EventBackupStale+" SK12")
+}
+
+func TestEvent_Title(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Type: EventBackupStale, Repository: "local", Hostname: "SK12", Tags: []string{"kuma", "daily"}}, "restic backup stale: local SK12 [kuma,daily]"},
+		{Event{Type: EventSnapshotNew, Repository: "local", Hostname: "NAS"}, "restic snapshot created: local NAS"},
+		{Event{Type: EventCollectionFailed, Repository: "offsite"}, "restic failing: offsite"},
+		{Event{Type: EventCollectionRecovered, Repository: "offsite"}, "restic recovered: offsite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.event.Title(); got != tt.want {
+				t.Errorf("Title() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeCheckSource struct {
+	numErrors int
+	checkedAt time.Time
+}
+
+func (f *fakeCheckSource) LastCheck() (int, time.Time, bool) {
+	return f.numErrors, f.checkedAt, !f.checkedAt.IsZero()
+}
+
+func TestWatcher_Check_CheckFailed(t *testing.T) {
+	now := time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC)
+	check := &fakeCheckSource{}
+
+	watcher := NewWatcher([]Repository{{
+		Config:    config.Repository{Name: "local"},
+		Snapshots: &fakeSnapshotSource{},
+		Check:     check,
+	}})
+	watcher.now = func() time.Time { return now }
+
+	// no check finished yet
+	assertEvents(t, watcher.Check())
+
+	check.checkedAt = now.Add(-time.Hour)
+	assertEvents(t, watcher.Check())
+
+	// every finished check with errors is reported once
+	check.numErrors, check.checkedAt = 3, now
+	events := watcher.Check()
+	assertEvents(t, events, EventCheckFailed+" ")
+	if want := "restic check started at 2025-10-12T12:00:00Z found 3 errors"; events[0].Message != want {
+		t.Errorf("Message = %q, want %q", events[0].Message, want)
+	}
+	assertEvents(t, watcher.Check())
+
+	check.checkedAt = now.Add(24 * time.Hour)
+	assertEvents(t, watcher.Check(), EventCheckFailed+" ")
+}
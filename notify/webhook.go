@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"restic-stats-exporter/config"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+	FormatNtfy    = "ntfy"
+	FormatGotify  = "gotify"
+)
+
+const (
+	defaultMaxAttempts = 4
+	defaultBackoff     = time.Second
+	// queueSize is the number of events queued per webhook, further events are dropped while it is full.
+	queueSize = 64
+)
+
+// Notifier sends events to webhooks and retries failed deliveries with exponential backoff.
+type Notifier struct {
+	webhooks    []config.Webhook
+	queues      []chan Event
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewNotifier returns a Notifier for webhooks.
+// An error is returned if a webhook uses an unknown format or event type.
+func NewNotifier(webhooks []config.Webhook) (*Notifier, error) {
+	for i, w := range webhooks {
+		switch w.Format {
+		case "", FormatGeneric, FormatSlack, FormatNtfy, FormatGotify:
+		default:
+			return nil, fmt.Errorf("webhook %d: unknown format %q", i+1, w.Format)
+		}
+		for _, event := range w.Events {
+			if !slices.Contains(eventTypes, event) {
+				return nil, fmt.Errorf("webhook %d: unknown event %q", i+1, event)
+			}
+		}
+	}
+
+	queues := make([]chan Event, len(webhooks))
+	for i := range queues {
+		queues[i] = make(chan Event, queueSize)
+	}
+
+	return &Notifier{
+		webhooks:    webhooks,
+		queues:      queues,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+	}, nil
+}
+
+// Enqueue queues event for all webhooks subscribed to its type without waiting for the delivery by Run.
+// The event is dropped for webhooks whose queue is full.
+func (n *Notifier) Enqueue(event Event) {
+	for i, w := range n.webhooks {
+		if !subscribed(w, event) {
+			continue
+		}
+
+		select {
+		case n.queues[i] <- event:
+		default:
+			slog.Error("Dropped notification, too many pending deliveries", "webhook", w, "event", event.Type)
+		}
+	}
+}
+
+// Run delivers the queued events until ctx is cancelled, every webhook in its own goroutine,
+// so a slow or unreachable webhook only delays its own notifications.
+func (n *Notifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i, w := range n.webhooks {
+		wg.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-n.queues[i]:
+					if err := n.send(ctx, w, event); err != nil {
+						slog.Error("Failed to send notification", "webhook", w, "event", event.Type, "error", err)
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// subscribed reports whether w receives events of the type of event.
+func subscribed(w config.Webhook, event Event) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event.Type)
+}
+
+// send delivers event to w, retrying on network errors, 429 and 5xx responses.
+func (n *Notifier) send(ctx context.Context, w config.Webhook, event Event) error {
+	backoff := n.backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = n.post(ctx, w, event)
+		if err == nil || !retry || attempt == n.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, w config.Webhook, event Event) (retry bool, err error) {
+	req, err := newRequest(ctx, w, event)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// newRequest builds the request delivering event in the format of w.
+func newRequest(ctx context.Context, w config.Webhook, event Event) (*http.Request, error) {
+	var body []byte
+	contentType := "application/json"
+	headers := map[string]string{}
+
+	switch w.Format {
+	case FormatSlack:
+		body, _ = json.Marshal(map[string]string{"text": fmt.Sprintf("*%s*\n%s", event.Title(), event.Message)})
+	case FormatNtfy:
+		body = []byte(event.Message)
+		contentType = "text/plain; charset=utf-8"
+		headers["Title"] = event.Title()
+		headers["Tags"] = event.Type
+		headers["Priority"] = fmt.Sprint(priority(event) / 2)
+	case FormatGotify:
+		body, _ = json.Marshal(map[string]any{"title": event.Title(), "message": event.Message, "priority": priority(event)})
+	default:
+		body, _ = json.Marshal(event)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return req, nil
+}
+
+// priority returns the Gotify priority of event, 8 for problems and 4 otherwise.
+// ntfy priorities range from 1 to 5, so half of it is used there.
+func priority(event Event) int {
+	switch event.Type {
+	case EventBackupStale, EventCollectionFailed, EventCheckFailed:
+		return 8
+	}
+	return 4
+}
@@ -0,0 +1,263 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"restic-stats-exporter/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var staleEvent = Event{
+	Type:       EventBackupStale,
+	Repository: "local",
+	Hostname:   "SK12",
+	Tags:       []string{"kuma"},
+	Time:       time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC),
+	Message:    "Last snapshot created at 2025-10-11T05:23:09Z, expected at most every 24h0m0s",
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   string
+}
+
+// newReceiver starts a webhook receiver answering with the given status codes in order and 200 afterwards.
+func newReceiver(t *testing.T, statusCodes ...int) (*httptest.Server, func() []receivedRequest) {
+	var mu sync.Mutex
+	var requests []receivedRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, receivedRequest{header: r.Header, body: string(body)})
+		if len(requests) <= len(statusCodes) {
+			w.WriteHeader(statusCodes[len(requests)-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedRequest(nil), requests...)
+	}
+}
+
+func newTestNotifier(t *testing.T, webhooks ...config.Webhook) *Notifier {
+	notifier, err := NewNotifier(webhooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.backoff = time.Millisecond
+	return notifier
+}
+
+// deliveredEvent is enqueued by deliver after the events under test to wait for their delivery.
+var deliveredEvent = Event{
+	Type:       EventCollectionFailed,
+	Repository: "local",
+	Time:       time.Date(2025, 10, 12, 12, 0, 0, 0, time.UTC),
+	Message:    "all events delivered",
+}
+
+// deliver runs notifier, enqueues events and returns the requests received for them.
+// Events are delivered in order per webhook, so they are done when deliveredEvent was received.
+func deliver(t *testing.T, notifier *Notifier, requests func() []receivedRequest, events ...Event) []receivedRequest {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifier.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for _, event := range events {
+		notifier.Enqueue(event)
+	}
+	notifier.Enqueue(deliveredEvent)
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		received := requests()
+		if n := len(received); n > 0 && strings.Contains(received[n-1].body, deliveredEvent.Message) {
+			return received[:n-1]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not delivered, received %d requests", deliveredEvent.Type, len(received))
+		}
+	}
+}
+
+func TestNotifier_Run_Formats(t *testing.T) {
+	tests := []struct {
+		format      string
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			format:      FormatGeneric,
+			wantBody:    `{"type":"backup_stale","repository":"local","hostname":"SK12","tags":["kuma"],"time":"2025-10-12T12:00:00Z","message":"Last snapshot created at 2025-10-11T05:23:09Z, expected at most every 24h0m0s"}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			format:      FormatSlack,
+			wantBody:    `{"text":"*restic backup stale: local SK12 [kuma]*\nLast snapshot created at 2025-10-11T05:23:09Z, expected at most every 24h0m0s"}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			format:   FormatNtfy,
+			wantBody: "Last snapshot created at 2025-10-11T05:23:09Z, expected at most every 24h0m0s",
+			wantHeaders: map[string]string{
+				"Content-Type":  "text/plain; charset=utf-8",
+				"Title":         "restic backup stale: local SK12 [kuma]",
+				"Tags":          "backup_stale",
+				"Priority":      "4",
+				"Authorization": "Bearer tk_secret",
+			},
+		},
+		{
+			format:      FormatGotify,
+			wantBody:    `{"message":"Last snapshot created at 2025-10-11T05:23:09Z, expected at most every 24h0m0s","priority":8,"title":"restic backup stale: local SK12 [kuma]"}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			server, requests := newReceiver(t)
+			notifier := newTestNotifier(t, config.Webhook{
+				URL:     server.URL,
+				Format:  tt.format,
+				Headers: map[string]string{"Authorization": "Bearer tk_secret"},
+			})
+
+			received := deliver(t, notifier, requests, staleEvent)
+			if len(received) != 1 {
+				t.Fatalf("received %d requests, want 1", len(received))
+			}
+			if got := received[0].body; got != tt.wantBody && !jsonEqual(got, tt.wantBody) {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := received[0].header.Get(name); got != want {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifier_Run_Retry(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	notifier := newTestNotifier(t, config.Webhook{URL: server.URL})
+
+	if got := len(deliver(t, notifier, requests, staleEvent)); got != 3 {
+		t.Errorf("received %d requests, want 3", got)
+	}
+}
+
+func TestNotifier_Run_GivesUp(t *testing.T) {
+	server, requests := newReceiver(t, 500, 500, 500, 500, 500)
+	notifier := newTestNotifier(t, config.Webhook{URL: server.URL})
+
+	if got := len(deliver(t, notifier, requests, staleEvent)); got != defaultMaxAttempts {
+		t.Errorf("received %d requests, want %d", got, defaultMaxAttempts)
+	}
+}
+
+func TestNotifier_Run_NoRetryOnClientError(t *testing.T) {
+	server, requests := newReceiver(t, http.StatusUnauthorized)
+	notifier := newTestNotifier(t, config.Webhook{URL: server.URL})
+
+	if got := len(deliver(t, notifier, requests, staleEvent)); got != 1 {
+		t.Errorf("received %d requests, want 1", got)
+	}
+}
+
+func TestNotifier_Run_EventFilter(t *testing.T) {
+	server, requests := newReceiver(t)
+	notifier := newTestNotifier(t, config.Webhook{URL: server.URL, Events: []string{EventCollectionFailed}})
+
+	if got := len(deliver(t, notifier, requests, staleEvent)); got != 0 {
+		t.Errorf("received %d requests, want 0", got)
+	}
+}
+
+func TestNotifier_Run(t *testing.T) {
+	blocked := make(chan struct{})
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-blocked:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(unreachable.Close)
+	t.Cleanup(func() { close(blocked) })
+
+	server, requests := newReceiver(t)
+	notifier := newTestNotifier(t, config.Webhook{URL: unreachable.URL}, config.Webhook{URL: server.URL})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		notifier.Run(ctx)
+		close(done)
+	}()
+
+	// the blocked webhook doesn't delay the delivery to the other one
+	notifier.Enqueue(staleEvent)
+	notifier.Enqueue(staleEvent)
+	for deadline := time.Now().Add(5 * time.Second); len(requests()) < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d requests, want 2", len(requests()))
+		}
+	}
+
+	// Run returns when ctx is cancelled, also while a delivery is pending
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after ctx was cancelled")
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook config.Webhook
+		wantErr bool
+	}{
+		{name: "default format", webhook: config.Webhook{URL: "https://example.com"}, wantErr: false},
+		{name: "gotify", webhook: config.Webhook{URL: "https://example.com", Format: FormatGotify}, wantErr: false},
+		{name: "unknown format", webhook: config.Webhook{URL: "https://example.com", Format: "teams"}, wantErr: true},
+		{name: "unknown event", webhook: config.Webhook{URL: "https://example.com", Events: []string{"lock_stale"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNotifier([]config.Webhook{tt.webhook})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNotifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func jsonEqual(a string, b string) bool {
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,97 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// RepositoryAttribute is the resource attribute holding the repository name.
+const RepositoryAttribute = "restic.repository"
+
+// Repository is a restic repository whose metrics are exported.
+type Repository struct {
+	Name     string
+	Gatherer prometheus.Gatherer
+}
+
+// Exporter periodically exports the metrics of restic repositories via OTLP.
+// Every repository gets its own meter provider, so the repository is part of the resource attributes.
+// Endpoint, headers, TLS and export interval are configured with the standard OTEL_* environment variables.
+type Exporter struct {
+	providers []*sdkmetric.MeterProvider
+}
+
+// New starts exporting the metrics of repositories with the given protocol, either grpc or http.
+func New(ctx context.Context, protocol string, repositories []Repository) (*Exporter, error) {
+	e := &Exporter{}
+	for _, repository := range repositories {
+		exporter, err := newMetricExporter(ctx, protocol)
+		if err != nil {
+			_ = e.Shutdown(ctx)
+			return nil, err
+		}
+
+		res, err := resource.New(ctx,
+			resource.WithFromEnv(),
+			resource.WithTelemetrySDK(),
+			resource.WithAttributes(
+				attribute.String("service.name", "restic-stats-exporter"),
+				attribute.String("service.version", version.Version),
+				attribute.String(RepositoryAttribute, repository.Name),
+			),
+		)
+		if err != nil {
+			_ = e.Shutdown(ctx)
+			return nil, fmt.Errorf("create resource for repository %q: %w", repository.Name, err)
+		}
+
+		reader := sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithProducer(promBridge.NewMetricProducer(promBridge.WithGatherer(repository.Gatherer))),
+		)
+		e.providers = append(e.providers, sdkmetric.NewMeterProvider(
+			sdkmetric.WithResource(res),
+			sdkmetric.WithReader(reader),
+		))
+	}
+
+	return e, nil
+}
+
+func newMetricExporter(ctx context.Context, protocol string) (sdkmetric.Exporter, error) {
+	switch protocol {
+	case "grpc":
+		return otlpmetricgrpc.New(ctx)
+	case "http", "http/protobuf":
+		return otlpmetrichttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected grpc or http", protocol)
+	}
+}
+
+// ForceFlush exports the current metrics of all repositories immediately.
+func (e *Exporter) ForceFlush(ctx context.Context) error {
+	var errs []error
+	for _, provider := range e.providers {
+		errs = append(errs, provider.ForceFlush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown exports the current metrics a last time and stops the exporter.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, provider := range e.providers {
+		errs = append(errs, provider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
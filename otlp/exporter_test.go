@@ -0,0 +1,158 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricsv1 "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver is a minimal OTLP metrics receiver stand-in that records all exported resource metrics.
+type receiver struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+
+	mu              sync.Mutex
+	resourceMetrics []*metricsv1.ResourceMetrics
+}
+
+func (r *receiver) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resourceMetrics = append(r.resourceMetrics, req.GetResourceMetrics()...)
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var exportRequest collectormetrics.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(data, &exportRequest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	response, _ := r.Export(req.Context(), &exportRequest)
+	body, _ := proto.Marshal(response)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(body)
+}
+
+// gaugeValues returns the exported gauge values by repository resource attribute and metric name.
+func (r *receiver) gaugeValues() map[string]map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := map[string]map[string]float64{}
+	for _, rm := range r.resourceMetrics {
+		repository := ""
+		for _, attr := range rm.GetResource().GetAttributes() {
+			if attr.GetKey() == RepositoryAttribute {
+				repository = attr.GetValue().GetStringValue()
+			}
+		}
+		if values[repository] == nil {
+			values[repository] = map[string]float64{}
+		}
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				for _, dp := range m.GetGauge().GetDataPoints() {
+					values[repository][m.GetName()] = dp.GetAsDouble()
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+func newRepository(name string, snapshotCount float64) Repository {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "restic_snapshot_count_total",
+		Help: "Total number of snapshots in the repository",
+	})
+	gauge.Set(snapshotCount)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge)
+	return Repository{Name: name, Gatherer: registry}
+}
+
+func TestExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		start    func(t *testing.T, r *receiver)
+	}{
+		{
+			name:     "http",
+			protocol: "http",
+			start: func(t *testing.T, r *receiver) {
+				server := httptest.NewServer(r)
+				t.Cleanup(server.Close)
+				t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", server.URL+"/v1/metrics")
+			},
+		},
+		{
+			name:     "grpc",
+			protocol: "grpc",
+			start: func(t *testing.T, r *receiver) {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("failed to listen: %v", err)
+				}
+				server := grpc.NewServer()
+				collectormetrics.RegisterMetricsServiceServer(server, r)
+				go func() { _ = server.Serve(listener) }()
+				t.Cleanup(server.Stop)
+				t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "http://"+listener.Addr().String())
+				t.Setenv("OTEL_EXPORTER_OTLP_METRICS_INSECURE", "true")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &receiver{}
+			tt.start(t, r)
+
+			ctx := context.Background()
+			e, err := New(ctx, tt.protocol, []Repository{newRepository("local", 4), newRepository("offsite", 7)})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := e.ForceFlush(ctx); err != nil {
+				t.Fatalf("ForceFlush() error = %v", err)
+			}
+			if err := e.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+
+			got := r.gaugeValues()
+			if got["local"]["restic_snapshot_count_total"] != 4 {
+				t.Errorf("local snapshot count = %v, want 4", got["local"]["restic_snapshot_count_total"])
+			}
+			if got["offsite"]["restic_snapshot_count_total"] != 7 {
+				t.Errorf("offsite snapshot count = %v, want 7", got["offsite"]["restic_snapshot_count_total"])
+			}
+		})
+	}
+}
+
+func TestNew_UnsupportedProtocol(t *testing.T) {
+	if _, err := New(context.Background(), "udp", []Repository{newRepository("local", 1)}); err == nil {
+		t.Errorf("New() expected error for unsupported protocol")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"restic-stats-exporter/config"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// runPush collects the metrics of all repositories once or every RSE_PUSH_INTERVAL and pushes them to a Pushgateway.
+// If the metrics are pushed once, the process exits with a non-zero status if the collection or the push failed.
+func runPush(cfg config.Config) {
+	url := getEnvWithDefault("RSE_PUSHGATEWAY_URL", "")
+	if url == "" {
+		slog.Error("Environment variable is not set", "name", "RSE_PUSHGATEWAY_URL")
+		os.Exit(1)
+	}
+	job := getEnvWithDefault("RSE_PUSH_JOB", "restic_stats_exporter")
+	interval := getDurationEnvWithDefault("RSE_PUSH_INTERVAL", 0)
+	grouping, err := parseLabels(getEnvWithDefault("RSE_PUSH_GROUPING", ""))
+	if err != nil {
+		slog.Error("Invalid push grouping labels", "name", "RSE_PUSH_GROUPING", "error", err)
+		os.Exit(1)
+	}
+
+	registry, repositories := newOneShotRegistry(cfg)
+
+	if interval == 0 {
+		if err := gatherAndPush(registry, url, job, grouping); err != nil {
+			slog.Error("Push failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// restic check locks the repository exclusively, so it runs on its own schedule instead of on every push
+	for _, collectors := range repositories {
+		if collectors.check != nil {
+			go collectors.check.Run(ctx, collectors.repository.CheckPeriod())
+		}
+	}
+
+	pushEvery(ctx, interval, func() {
+		if err := gatherAndPush(registry, url, job, grouping); err != nil {
+			slog.Error("Push failed", "error", err)
+		}
+	})
+}
+
+// pushEvery calls push immediately and then every interval until ctx is done.
+func pushEvery(ctx context.Context, interval time.Duration, push func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		push()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gatherAndPush gathers the metrics of gatherer once and pushes them to the Pushgateway at url.
+// An error is returned if pushing failed or if any restic command exited with a non-zero exit code,
+// in which case the metrics are pushed nevertheless.
+func gatherAndPush(gatherer prometheus.Gatherer, url string, job string, grouping map[string]string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+
+	pusher := push.New(url, job).Gatherer(staticGatherer(families))
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	if err := pusher.Push(); err != nil {
+		return fmt.Errorf("push metrics: %w", err)
+	}
+	slog.Info("Pushed metrics", "url", url, "job", job)
+
+	if failed := failedCommands(families); len(failed) > 0 {
+		return fmt.Errorf("collection failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// failedCommands returns the exit code metrics of families reporting a non-zero exit code.
+func failedCommands(families []*dto.MetricFamily) []string {
+	var failed []string
+	for _, family := range families {
+		if !strings.HasSuffix(family.GetName(), "_exit_code") {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge().GetValue() == 0 {
+				continue
+			}
+
+			labels := make([]string, 0, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+			}
+			failed = append(failed, fmt.Sprintf("%s{%s} %g", family.GetName(), strings.Join(labels, ","), metric.GetGauge().GetValue()))
+		}
+	}
+
+	return failed
+}
+
+// parseLabels parses comma separated name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	if s == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q, expected name=value", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
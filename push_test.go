@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_parseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", s: "", want: map[string]string{}},
+		{name: "single", s: "instance=laptop", want: map[string]string{"instance": "laptop"}},
+		{name: "multiple", s: "instance=laptop, site=home", want: map[string]string{"instance": "laptop", "site": "home"}},
+		{name: "missing value", s: "instance", wantErr: true},
+		{name: "missing name", s: "=laptop", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseLabels() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabels() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gatherAndPush(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode float64
+		wantErr  bool
+	}{
+		{name: "success", exitCode: 0, wantErr: false},
+		{name: "failed collection", exitCode: 12, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, body string
+			pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				path, body = r.URL.Path, string(data)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer pushgateway.Close()
+
+			exitCode := prometheus.NewGauge(prometheus.GaugeOpts{Name: "restic_snapshot_exit_code"})
+			exitCode.Set(tt.exitCode)
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(exitCode)
+
+			err := gatherAndPush(registry, pushgateway.URL, "restic", map[string]string{"instance": "laptop"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gatherAndPush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if path != "/metrics/job/restic/instance/laptop" {
+				t.Errorf("unexpected push path: %s", path)
+			}
+			if !strings.Contains(body, "restic_snapshot_exit_code") {
+				t.Errorf("pushed metrics do not contain exit code")
+			}
+		})
+	}
+}
+
+func Test_gatherAndPush_PushgatewayError(t *testing.T) {
+	pushgateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer pushgateway.Close()
+
+	if err := gatherAndPush(prometheus.NewRegistry(), pushgateway.URL, "restic", nil); err == nil {
+		t.Errorf("gatherAndPush() expected error")
+	}
+}
+
+func Test_pushEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pushes := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pushEvery(ctx, time.Hour, func() {
+			pushes++
+			cancel()
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushEvery did not return after the context was cancelled")
+	}
+	if pushes != 1 {
+		t.Errorf("pushes = %d, want 1", pushes)
+	}
+}
@@ -0,0 +1,106 @@
+// Package relabel rewrites and drops the label values of the exported metrics according to the relabel rules of the config.
+package relabel
+
+import (
+	"regexp"
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/snapshot"
+	"slices"
+)
+
+// Rules applies relabel rules in the configured order.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	source      string
+	regex       *regexp.Regexp
+	drop        bool
+	replacement string
+}
+
+// New compiles the relabel rules.
+func New(rules []config.RelabelRule) (*Rules, error) {
+	r := &Rules{}
+	for _, c := range rules {
+		regex, err := c.Compile()
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule{source: c.Source, regex: regex, drop: c.Action == "drop", replacement: c.Replacement})
+	}
+	return r, nil
+}
+
+// apply returns value rewritten by the rule, false if the rule drops it.
+func (r rule) apply(value string) (string, bool) {
+	if !r.regex.MatchString(value) {
+		return value, true
+	}
+	if r.drop {
+		return "", false
+	}
+	return r.regex.ReplaceAllString(value, r.replacement), true
+}
+
+// applyAll rewrites every value, values rewritten to an empty string are removed.
+// It returns false if the rule drops one of the values and never modifies values.
+func (r rule) applyAll(values []string) ([]string, bool) {
+	rewritten := make([]string, 0, len(values))
+	for _, value := range values {
+		value, ok := r.apply(value)
+		if !ok {
+			return nil, false
+		}
+		if value != "" {
+			rewritten = append(rewritten, value)
+		}
+	}
+	return rewritten, true
+}
+
+// Repository returns the relabeled repository name, false if the metrics of the repository are dropped.
+// A repository relabeled to an empty name is dropped as well.
+func (r *Rules) Repository(name string) (string, bool) {
+	for _, rule := range r.rules {
+		if rule.source != "repository" {
+			continue
+		}
+		var ok bool
+		if name, ok = rule.apply(name); !ok {
+			return "", false
+		}
+	}
+	return name, name != ""
+}
+
+// Group returns group with the relabeled hostname, tags and paths, false if the group is dropped.
+// The snapshots of group are copied before their paths are rewritten.
+func (r *Rules) Group(group snapshot.GroupData) (snapshot.GroupData, bool) {
+	copied := false
+	var ok bool
+	for _, rule := range r.rules {
+		switch rule.source {
+		case "hostname":
+			if group.GroupKey.Hostname, ok = rule.apply(group.GroupKey.Hostname); !ok {
+				return group, false
+			}
+		case "tags":
+			if group.GroupKey.Tags, ok = rule.applyAll(group.GroupKey.Tags); !ok {
+				return group, false
+			}
+		case "paths":
+			if !copied {
+				group.Snapshots = slices.Clone(group.Snapshots)
+				copied = true
+			}
+			for i := range group.Snapshots {
+				if group.Snapshots[i].Paths, ok = rule.applyAll(group.Snapshots[i].Paths); !ok {
+					return group, false
+				}
+			}
+		}
+	}
+	return group, true
+}
@@ -0,0 +1,101 @@
+package relabel
+
+import (
+	"reflect"
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/snapshot"
+	"testing"
+)
+
+func TestRules_Group(t *testing.T) {
+	rules, err := New([]config.RelabelRule{
+		{Source: "hostname", Regex: `([^.]+)\..*`, Replacement: "$1"},
+		{Source: "hostname", Regex: `[0-9a-f]{12}`, Action: "drop"},
+		{Source: "tags", Regex: `customer-.*`, Replacement: ""},
+		{Source: "tags", Regex: `tmp`, Action: "drop"},
+		{Source: "paths", Regex: `/home/([^/]+)(/.*)?`, Replacement: "/home/user$2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		group  snapshot.GroupData
+		want   snapshot.GroupData
+		wantOK bool
+	}{
+		{
+			name: "rewritten",
+			group: snapshot.GroupData{
+				GroupKey:  snapshot.GroupKey{Hostname: "web1.example.com", Tags: []string{"daily", "customer-acme"}},
+				Snapshots: []snapshot.Snapshot{{Paths: []string{"/home/alice/documents", "/etc"}}},
+			},
+			want: snapshot.GroupData{
+				GroupKey:  snapshot.GroupKey{Hostname: "web1", Tags: []string{"daily"}},
+				Snapshots: []snapshot.Snapshot{{Paths: []string{"/home/user/documents", "/etc"}}},
+			},
+			wantOK: true,
+		},
+		{
+			name:   "container hostname dropped",
+			group:  snapshot.GroupData{GroupKey: snapshot.GroupKey{Hostname: "3f2a9c1b7e4d"}},
+			wantOK: false,
+		},
+		{
+			name:   "tag dropped",
+			group:  snapshot.GroupData{GroupKey: snapshot.GroupKey{Hostname: "web1", Tags: []string{"daily", "tmp"}}},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.group.Snapshots
+			var originalPaths []string
+			if len(original) > 0 {
+				originalPaths = append(originalPaths, original[0].Paths...)
+			}
+
+			got, ok := rules.Group(tt.group)
+			if ok != tt.wantOK {
+				t.Fatalf("Group() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Group() = %+v, want %+v", got, tt.want)
+			}
+			if len(original) > 0 && !reflect.DeepEqual(original[0].Paths, originalPaths) {
+				t.Errorf("Group() modified the snapshots of the group: %v", original[0].Paths)
+			}
+		})
+	}
+}
+
+func TestRules_Repository(t *testing.T) {
+	rules, err := New([]config.RelabelRule{
+		{Source: "repository", Regex: `test-.*`, Action: "drop"},
+		{Source: "repository", Regex: `prod-(.*)`, Replacement: "$1"},
+		{Source: "repository", Regex: `scratch`, Replacement: ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{name: "prod-offsite", want: "offsite", wantOK: true},
+		{name: "local", want: "local", wantOK: true},
+		{name: "test-local", wantOK: false},
+		{name: "scratch", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rules.Repository(tt.name)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("Repository() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
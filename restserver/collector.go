@@ -0,0 +1,213 @@
+// Package restserver queries rest-server for the on-disk size of the repositories it serves.
+package restserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"restic-stats-exporter/util"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fileTypes are the directories of a repository, config is a single file and queried separately.
+var fileTypes = []string{"data", "index", "keys", "locks", "snapshots"}
+
+// apiV2 selects version 2 of the REST backend API, which lists files with their sizes.
+const apiV2 = "application/vnd.x.restic.rest.v2"
+
+type Collector struct {
+	url      *url.URL
+	username string
+	password string
+	client   *http.Client
+	cache    util.Cache[result]
+
+	mu sync.Mutex
+	// appendOnly is the configured append-only mode, nil if unknown.
+	appendOnly *bool
+}
+
+// result holds the outcome of a single query of rest-server.
+type result struct {
+	sizes  map[string]int64
+	counts map[string]int
+}
+
+// NewCollector returns a collector for the repository at location, a restic rest: repository URL.
+// Credentials in the URL take precedence over username and password.
+func NewCollector(location, username, password string, client *http.Client) (*Collector, error) {
+	u, err := ParseLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+		u.User = nil
+	}
+
+	return &Collector{
+		url:      u,
+		username: username,
+		password: password,
+		client:   client,
+	}, nil
+}
+
+// ParseLocation returns the HTTP URL of a restic rest: repository location.
+func ParseLocation(location string) (*url.URL, error) {
+	rest, ok := strings.CutPrefix(location, "rest:")
+	if !ok {
+		return nil, fmt.Errorf("repository %q is not a rest: repository", location)
+	}
+	u, err := url.Parse(rest)
+	if err != nil {
+		return nil, fmt.Errorf("parse rest-server url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("rest-server url must be an absolute http or https URL")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- sizeDesc
+	ch <- fileCountDesc
+	ch <- appendOnlyDesc
+}
+
+// ReportAppendOnly makes the collector export the configured append-only mode, which rest-server doesn't report.
+func (c *Collector) ReportAppendOnly(appendOnly bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.appendOnly = &appendOnly
+}
+
+// Run queries rest-server every interval until ctx is cancelled, Collect serves the last result meanwhile.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	c.cache.Run(ctx, interval, c.refresh, nil)
+}
+
+// refresh lists the files of the repository.
+func (c *Collector) refresh(ctx context.Context) (result, bool) {
+	res := result{sizes: map[string]int64{}, counts: map[string]int{}}
+
+	for _, fileType := range fileTypes {
+		size, count, err := c.list(ctx, fileType)
+		if err != nil {
+			return result{}, false
+		}
+		res.sizes[fileType], res.counts[fileType] = size, count
+	}
+
+	size, err := c.configSize(ctx)
+	if err != nil {
+		return result{}, false
+	}
+	res.sizes["config"], res.counts["config"] = size, 1
+
+	return res, true
+}
+
+// list returns the total size and number of the files of fileType. rest-server lists the data files of all subdirectories.
+func (c *Collector) list(ctx context.Context, fileType string) (int64, int, error) {
+	resp, err := c.do(ctx, http.MethodGet, fileType+"/")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("list %s: %s", fileType, resp.Status)
+	}
+
+	var files []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return 0, 0, fmt.Errorf("list %s: %w", fileType, err)
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	return size, len(files), nil
+}
+
+// configSize returns the size of the config file.
+func (c *Collector) configSize(ctx context.Context) (int64, error) {
+	resp, err := c.do(ctx, http.MethodHead, "config")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("config: %s", resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+func (c *Collector) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url.JoinPath(path).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, "/") && !strings.HasSuffix(req.URL.Path, "/") {
+		req.URL.Path += "/"
+	}
+	req.Header.Set("Accept", apiV2)
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	return c.client.Do(req)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	cached, ok := c.cache.Current(context.Background(), c.refresh)
+	if !ok {
+		// the first query is still running
+		return
+	}
+	res := cached.Value
+
+	if !cached.OK {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+
+	for fileType, size := range res.sizes {
+		ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(size), fileType)
+		ch <- prometheus.MustNewConstMetric(fileCountDesc, prometheus.GaugeValue, float64(res.counts[fileType]), fileType)
+	}
+
+	c.mu.Lock()
+	appendOnly := c.appendOnly
+	c.mu.Unlock()
+	if appendOnly != nil {
+		ch <- prometheus.MustNewConstMetric(appendOnlyDesc, prometheus.GaugeValue, boolToFloat(*appendOnly))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package restserver
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	upDesc = prometheus.NewDesc(
+		"restic_rest_server_up",
+		"Whether the repository could be queried on rest-server",
+		nil, nil,
+	)
+
+	sizeDesc = prometheus.NewDesc(
+		"restic_rest_server_size_bytes",
+		"On-disk size of the files of the repository on rest-server by file type",
+		[]string{"type"}, nil,
+	)
+
+	fileCountDesc = prometheus.NewDesc(
+		"restic_rest_server_file_count",
+		"Number of files of the repository on rest-server by file type",
+		[]string{"type"}, nil,
+	)
+
+	appendOnlyDesc = prometheus.NewDesc(
+		"restic_rest_server_append_only",
+		"Whether rest-server serves the repository in append-only mode according to the configuration",
+		nil, nil,
+	)
+)
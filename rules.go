@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"restic-stats-exporter/config"
+	"restic-stats-exporter/rules"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// runRules writes Prometheus alerting rules for the configured repositories to stdout.
+func runRules(cfg config.Config) {
+	data, err := yaml.Marshal(rules.Generate(cfg))
+	if err != nil {
+		slog.Error("Failed to generate rules", "error", err)
+		os.Exit(1)
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		slog.Error("Failed to write rules", "error", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,191 @@
+package rules
+
+import (
+	"fmt"
+	"restic-stats-exporter/config"
+	"strings"
+	"time"
+)
+
+// RuleFile is a Prometheus rule file.
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a named group of rules evaluated together.
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a Prometheus alerting rule.
+type Rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Generate returns alerting rules for all repositories of cfg.
+// Every repository gets its own rule group alerting on stale or missing snapshot groups, changed backup scopes, anomalous snapshots,
+// restic commands exiting with a non-zero exit code and collections that stopped reporting.
+// Repositories with locks or check enabled additionally alert on stale locks and failed checks.
+func Generate(cfg config.Config) RuleFile {
+	var file RuleFile
+	for _, repository := range cfg.Repositories {
+		file.Groups = append(file.Groups, repositoryRules(repository))
+	}
+	return file
+}
+
+func repositoryRules(repository config.Repository) RuleGroup {
+	group := RuleGroup{Name: "restic-" + repository.Name}
+	repositoryMatcher := fmt.Sprintf("repository=%q", repository.Name)
+
+	// the repository wide threshold applies to all groups without their own rule
+	expr := fmt.Sprintf("time() - restic_last_snapshot_time_seconds{%s} > %d", repositoryMatcher, seconds(repository.StaleThreshold()))
+	for _, g := range repository.Groups {
+		expr += fmt.Sprintf(" unless on(restic_hostname, restic_tags) restic_last_snapshot_time_seconds{%s}", groupMatcher(repository, g))
+	}
+	group.Rules = append(group.Rules, staleRule(repository.Name, expr, repository.StaleThreshold()))
+
+	for _, g := range repository.Groups {
+		threshold := repository.GroupStaleThreshold(g)
+		group.Rules = append(group.Rules, staleRule(
+			repository.Name,
+			fmt.Sprintf("time() - restic_last_snapshot_time_seconds{%s} > %d", groupMatcher(repository, g), seconds(threshold)),
+			threshold,
+		))
+	}
+
+	for _, g := range repository.Groups {
+		group.Rules = append(group.Rules, Rule{
+			Alert: "ResticBackupMissing",
+			// only alert if restic could list the snapshots, otherwise ResticCommandFailed fires
+			Expr: fmt.Sprintf("absent(restic_snapshot_count{%s}) and on() restic_snapshot_exit_code{%s} == 0", groupMatcher(repository, g), repositoryMatcher),
+			For:  "15m",
+			Labels: map[string]string{
+				"severity": "critical",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("No snapshots of %s in repository %s", g, repository.Name),
+				"description": fmt.Sprintf("Repository %s contains no snapshots of host %s with tags %q.", repository.Name, g.Hostname, g.JoinedTags()),
+			},
+		})
+	}
+
+	group.Rules = append(group.Rules,
+		Rule{
+			Alert: "ResticBackupAnomalous",
+			Expr:  fmt.Sprintf("restic_last_snapshot_anomalous{%s} == 1", repositoryMatcher),
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Backup of {{ $labels.restic_hostname }} in repository %s processed an unusual amount of data", repository.Name),
+				"description": "The last snapshot of {{ $labels.restic_hostname }} with tags \"{{ $labels.restic_tags }}\" processed far fewer or more bytes or files than the snapshots before, e.g. because a mount was missing.",
+			},
+		},
+		Rule{
+			Alert: "ResticCommandFailed",
+			Expr:  fmt.Sprintf("restic_snapshot_exit_code{%[1]s} != 0 or restic_stats_exit_code{%[1]s} != 0", repositoryMatcher),
+			For:   "15m",
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("restic command against repository %s failed", repository.Name),
+				"description": "{{ $labels.__name__ }} is {{ $value }}. See the /status page of the exporter for the error output.",
+			},
+		},
+		Rule{
+			Alert: "ResticBackupScopeChanged",
+			Expr:  fmt.Sprintf("restic_last_snapshot_scope_changed{%[1]s} == 1 or restic_last_snapshot_scope_unexpected{%[1]s} == 1", repositoryMatcher),
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Backup scope of {{ $labels.restic_hostname }} in repository %s changed", repository.Name),
+				"description": "The paths or excludes of the last snapshot of {{ $labels.restic_hostname }} with tags \"{{ $labels.restic_tags }}\" differ from the snapshot before or from the configured ones.",
+			},
+		},
+		Rule{
+			Alert: "ResticCollectionMissing",
+			Expr:  fmt.Sprintf("absent(restic_snapshot_exit_code{%s})", repositoryMatcher),
+			For:   "15m",
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("No metrics collected for repository %s", repository.Name),
+				"description": fmt.Sprintf("The exporter did not report the snapshots of repository %s, it is either not scraped or the first refresh did not finish.", repository.Name),
+			},
+		},
+	)
+
+	if repository.Locks {
+		threshold := repository.LockStaleThreshold()
+		group.Rules = append(group.Rules, Rule{
+			Alert: "ResticLockStale",
+			Expr:  fmt.Sprintf("time() - restic_lock_oldest_time_seconds{%s} > %d", repositoryMatcher, seconds(threshold)),
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("Repository %s has a stale lock", repository.Name),
+				"description": fmt.Sprintf("The oldest lock of repository %s is {{ $value | humanizeDuration }} old, expected at most %s. A crashed restic process may block prune and check, see restic unlock.", repository.Name, formatDuration(threshold)),
+			},
+		})
+	}
+
+	if repository.Check {
+		group.Rules = append(group.Rules, Rule{
+			Alert: "ResticCheckFailed",
+			Expr:  fmt.Sprintf("restic_check_exit_code{%[1]s} != 0 or restic_check_errors{%[1]s} > 0", repositoryMatcher),
+			Labels: map[string]string{
+				"severity": "critical",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("restic check of repository %s failed", repository.Name),
+				"description": "{{ $labels.__name__ }} is {{ $value }}. See the /status page of the exporter for the error output.",
+			},
+		})
+	}
+
+	return group
+}
+
+func staleRule(repositoryName string, expr string, threshold time.Duration) Rule {
+	return Rule{
+		Alert: "ResticBackupStale",
+		Expr:  expr,
+		Labels: map[string]string{
+			"severity": "critical",
+		},
+		Annotations: map[string]string{
+			"summary":     fmt.Sprintf("Backup of {{ $labels.restic_hostname }} in repository %s is stale", repositoryName),
+			"description": fmt.Sprintf("The last snapshot of {{ $labels.restic_hostname }} with tags \"{{ $labels.restic_tags }}\" is {{ $value | humanizeDuration }} old, expected at most %s.", formatDuration(threshold)),
+		},
+	}
+}
+
+func groupMatcher(repository config.Repository, group config.Group) string {
+	return fmt.Sprintf("repository=%q, restic_hostname=%q, restic_tags=%q", repository.Name, group.Hostname, group.JoinedTags())
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// formatDuration formats d without trailing zero units, e.g. 36h instead of 36h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
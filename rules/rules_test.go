@@ -0,0 +1,65 @@
+package rules
+
+import (
+	"flag"
+	"os"
+	"restic-stats-exporter/config"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+	cfg := config.Config{Repositories: []config.Repository{
+		{
+			Name:       "local",
+			StaleAfter: 36 * time.Hour,
+			Groups: []config.Group{
+				{Hostname: "SK12", Tags: []string{"kuma"}},
+				{Hostname: "DPC1", Tags: []string{"full-server", "daily"}, StaleAfter: 192 * time.Hour},
+			},
+		},
+		{Name: "offsite", Locks: true, LockStaleAfter: 2 * time.Hour, Check: true},
+	}}
+
+	got, err := yaml.Marshal(Generate(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := os.WriteFile("testdata/rules.yml", got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile("testdata/rules.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Generate() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_formatDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{36 * time.Hour, "36h"},
+		{90 * time.Minute, "1h30m"},
+		{time.Minute, "1m"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := formatDuration(tt.duration); got != tt.want {
+				t.Errorf("formatDuration(%v) = %q, want %q", tt.duration, got, tt.want)
+			}
+		})
+	}
+}
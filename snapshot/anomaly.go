@@ -0,0 +1,90 @@
+package snapshot
+
+import (
+	"math"
+	"slices"
+)
+
+// minAnomalyHistory is the number of earlier snapshots a group needs before its last snapshot is compared to them.
+const minAnomalyHistory = 3
+
+// AnomalyThresholds configures the comparison of the last snapshot of a group to the median of the snapshots before.
+type AnomalyThresholds struct {
+	// Window is the number of earlier snapshots the median is computed from.
+	Window int
+	// MinRatio is the ratio to the median below which the last snapshot is anomalous.
+	MinRatio float64
+	// MaxRatio is the ratio to the median above which the last snapshot is anomalous, 0 disables the upper bound.
+	MaxRatio float64
+}
+
+// processedRatios holds the ratios of the bytes and files processed by the last snapshot of a group to the median
+// of the snapshots before.
+type processedRatios struct {
+	Bytes float64
+	Files float64
+}
+
+// processedRatiosOf compares the last snapshot of group to the median of the window snapshots before.
+// ok is false if fewer than minAnomalyHistory earlier snapshots are retained.
+func processedRatiosOf(group GroupData, window int) (ratios processedRatios, ok bool) {
+	if window <= 0 || len(group.Snapshots) < minAnomalyHistory+1 {
+		return processedRatios{}, false
+	}
+
+	snapshots := slices.Clone(group.Snapshots)
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	last, earlier := snapshots[len(snapshots)-1], snapshots[:len(snapshots)-1]
+	if len(earlier) > window {
+		earlier = earlier[len(earlier)-window:]
+	}
+
+	bytes := make([]int, len(earlier))
+	files := make([]int, len(earlier))
+	for i, s := range earlier {
+		bytes[i], files[i] = s.Summary.TotalBytesProcessed, s.Summary.TotalFilesProcessed
+	}
+
+	return processedRatios{
+		Bytes: ratio(last.Summary.TotalBytesProcessed, median(bytes)),
+		Files: ratio(last.Summary.TotalFilesProcessed, median(files)),
+	}, true
+}
+
+// anomalous reports whether one of the ratios is outside the thresholds.
+func (r processedRatios) anomalous(thresholds AnomalyThresholds) bool {
+	for _, ratio := range []float64{r.Bytes, r.Files} {
+		if ratio < thresholds.MinRatio {
+			return true
+		}
+		if thresholds.MaxRatio > 0 && ratio > thresholds.MaxRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// median returns the median of values, which must not be empty.
+func median(values []int) float64 {
+	values = slices.Clone(values)
+	slices.Sort(values)
+
+	n := len(values)
+	if n%2 == 1 {
+		return float64(values[n/2])
+	}
+	return (float64(values[n/2-1]) + float64(values[n/2])) / 2
+}
+
+// ratio returns value divided by median. Without earlier data a value of 0 is as usual and any other value is infinitely larger.
+func ratio(value int, median float64) float64 {
+	if median == 0 {
+		if value == 0 {
+			return 1
+		}
+		return math.Inf(1)
+	}
+	return float64(value) / median
+}
@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"slices"
+	"strings"
+)
+
+// index holds the snapshots loaded by previous refreshes grouped like restic snapshots --group-by host,tags,
+// so a refresh only has to load the snapshots added since.
+type index struct {
+	keep   int
+	groups []*GroupData
+	byKey  map[string]*GroupData
+	byID   map[string]*GroupData
+}
+
+// newIndex returns an empty index that retains the keep most recent snapshots of every group.
+func newIndex(keep int) *index {
+	return &index{
+		keep:  keep,
+		byKey: map[string]*GroupData{},
+		byID:  map[string]*GroupData{},
+	}
+}
+
+// groupKeyOf returns the key of the group restic puts snapshot in when grouping by host and tags.
+// restic sorts the tags of the group key.
+func groupKeyOf(snapshot Snapshot) GroupKey {
+	var tags []string
+	if len(snapshot.Tags) > 0 {
+		tags = slices.Clone(snapshot.Tags)
+		slices.Sort(tags)
+	}
+	return GroupKey{Hostname: snapshot.Hostname, Tags: tags}
+}
+
+func (k GroupKey) id() string {
+	return k.Hostname + "\x00" + strings.Join(k.Tags, "\x00")
+}
+
+// contains reports whether the snapshot with id is part of the index.
+func (x *index) contains(id string) bool {
+	_, ok := x.byID[id]
+	return ok
+}
+
+// add adds snapshot to its group. Snapshots already part of the index are ignored.
+func (x *index) add(snapshot Snapshot) {
+	if x.contains(snapshot.ID) {
+		return
+	}
+
+	key := groupKeyOf(snapshot)
+	group, ok := x.byKey[key.id()]
+	if !ok {
+		group = &GroupData{GroupKey: key}
+		x.byKey[key.id()] = group
+		x.groups = append(x.groups, group)
+	}
+
+	group.add(snapshot, x.keep)
+	x.byID[snapshot.ID] = group
+}
+
+// remove removes the snapshot with id from its group.
+// It returns false if the group has no retained snapshots left while it still contains other snapshots,
+// so the most recent snapshot of the group is unknown and the index has to be rebuilt.
+func (x *index) remove(id string) bool {
+	group, ok := x.byID[id]
+	if !ok {
+		return true
+	}
+	delete(x.byID, id)
+
+	group.Count--
+	group.unwrap()
+	group.Snapshots = slices.DeleteFunc(group.Snapshots, func(s Snapshot) bool {
+		return s.ID == id
+	})
+
+	if group.Count == 0 {
+		delete(x.byKey, group.GroupKey.id())
+		x.groups = slices.DeleteFunc(x.groups, func(g *GroupData) bool {
+			return g == group
+		})
+		return true
+	}
+	return len(group.Snapshots) > 0
+}
+
+// ids returns the IDs of all snapshots in the index.
+func (x *index) ids() []string {
+	ids := make([]string, 0, len(x.byID))
+	for id := range x.byID {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// groupData returns a copy of the groups that is not modified by later changes of the index.
+func (x *index) groupData() []GroupData {
+	groupData := make([]GroupData, 0, len(x.groups))
+	for _, group := range x.groups {
+		group.unwrap()
+		groupData = append(groupData, GroupData{
+			GroupKey:  group.GroupKey,
+			Snapshots: slices.Clone(group.Snapshots),
+			Count:     group.Count,
+		})
+	}
+	return groupData
+}
+
+// GroupByHostAndTags groups the snapshots list passes to add like restic snapshots --group-by host,tags
+// and retains the keep most recent snapshots of every group, so the snapshots may be passed in any order.
+func GroupByHostAndTags(keep int, list func(add func(Snapshot)) error) ([]GroupData, error) {
+	x := newIndex(keep)
+	if err := list(x.add); err != nil {
+		return nil, err
+	}
+	return x.groupData(), nil
+}
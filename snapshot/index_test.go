@@ -0,0 +1,121 @@
+package snapshot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshot(n int, hostname string, tags ...string) Snapshot {
+	id := strings.Repeat(string(rune('0'+n)), 64)
+	return Snapshot{
+		ID:       id,
+		ShortID:  id[:8],
+		Time:     time.Date(2025, 10, n, 0, 0, 0, 0, time.UTC),
+		Hostname: hostname,
+		Tags:     tags,
+	}
+}
+
+func Test_index_add(t *testing.T) {
+	x := newIndex(2)
+	x.add(testSnapshot(1, "SK12", "kuma", "daily"))
+	x.add(testSnapshot(2, "SK12", "daily", "kuma"))
+	x.add(testSnapshot(3, "SK12"))
+	x.add(testSnapshot(4, "SK12", "kuma", "daily"))
+	x.add(testSnapshot(4, "SK12", "kuma", "daily"))
+
+	want := []GroupData{
+		{
+			GroupKey:  GroupKey{Hostname: "SK12", Tags: []string{"daily", "kuma"}},
+			Snapshots: []Snapshot{testSnapshot(2, "SK12", "daily", "kuma"), testSnapshot(4, "SK12", "kuma", "daily")},
+			Count:     3,
+		},
+		{
+			GroupKey:  GroupKey{Hostname: "SK12"},
+			Snapshots: []Snapshot{testSnapshot(3, "SK12")},
+			Count:     1,
+		},
+	}
+	if got := x.groupData(); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupData() got = %+v, want %+v", got, want)
+	}
+}
+
+func Test_index_remove(t *testing.T) {
+	tests := []struct {
+		name       string
+		remove     []string
+		wantOk     bool
+		wantGroups int
+		wantCount  int
+	}{
+		{
+			name:       "unknown snapshot",
+			remove:     []string{strings.Repeat("9", 64)},
+			wantOk:     true,
+			wantGroups: 2,
+			wantCount:  4,
+		},
+		{
+			name:       "snapshot not retained",
+			remove:     []string{testSnapshot(1, "SK12").ID},
+			wantOk:     true,
+			wantGroups: 2,
+			wantCount:  3,
+		},
+		{
+			name:       "last snapshot of a group",
+			remove:     []string{testSnapshot(4, "SK13").ID},
+			wantOk:     true,
+			wantGroups: 1,
+			wantCount:  3,
+		},
+		{
+			name:       "last retained snapshot of a group",
+			remove:     []string{testSnapshot(3, "SK12").ID},
+			wantOk:     false,
+			wantGroups: 2,
+			wantCount:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := newIndex(1)
+			x.add(testSnapshot(1, "SK12"))
+			x.add(testSnapshot(2, "SK12"))
+			x.add(testSnapshot(3, "SK12"))
+			x.add(testSnapshot(4, "SK13"))
+
+			ok := true
+			for _, id := range tt.remove {
+				ok = x.remove(id) && ok
+			}
+			if ok != tt.wantOk {
+				t.Errorf("remove() = %v, want %v", ok, tt.wantOk)
+			}
+
+			groupData := x.groupData()
+			if len(groupData) != tt.wantGroups {
+				t.Errorf("groups = %d, want %d", len(groupData), tt.wantGroups)
+			}
+			if got := getTotalSnapshotCount(groupData); got != tt.wantCount {
+				t.Errorf("snapshot count = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func Test_index_groupData_IsCopy(t *testing.T) {
+	x := newIndex(0)
+	x.add(testSnapshot(1, "SK12"))
+	groupData := x.groupData()
+
+	x.add(testSnapshot(2, "SK12"))
+	x.remove(testSnapshot(1, "SK12").ID)
+
+	if len(groupData[0].Snapshots) != 1 || groupData[0].Snapshots[0].ID != testSnapshot(1, "SK12").ID {
+		t.Errorf("groupData() was modified by the index: %+v", groupData)
+	}
+}
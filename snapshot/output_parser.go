@@ -1,14 +1,34 @@
 package snapshot
 
 import (
+	"bufio"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
 	"time"
 )
 
+// DefaultSnapshotHistory is the number of most recent snapshots retained per group while decoding.
+const DefaultSnapshotHistory = 100
+
 type GroupData struct {
 	GroupKey  GroupKey   `json:"group_key"`
 	Snapshots []Snapshot `json:"snapshots"`
+	// Count is the number of snapshots in the group, Snapshots may only hold the most recent of them.
+	Count int `json:"count,omitempty"`
+	// head is the position of the oldest snapshot while add uses Snapshots as a ring buffer.
+	head int
+}
+
+// SnapshotCount returns the number of snapshots in the group.
+func (g GroupData) SnapshotCount() int {
+	if g.Count > len(g.Snapshots) {
+		return g.Count
+	}
+	return len(g.Snapshots)
 }
 
 type GroupKey struct {
@@ -17,10 +37,16 @@ type GroupKey struct {
 }
 
 type Snapshot struct {
-	Time     time.Time `json:"time"`
-	Hostname string    `json:"hostname"`
-	Tags     []string  `json:"tags"`
-	Summary  Summary   `json:"summary"`
+	ID             string    `json:"id"`
+	ShortID        string    `json:"short_id"`
+	Time           time.Time `json:"time"`
+	Paths          []string  `json:"paths"`
+	Excludes       []string  `json:"excludes"`
+	Hostname       string    `json:"hostname"`
+	Tags           []string  `json:"tags"`
+	Username       string    `json:"username"`
+	ProgramVersion string    `json:"program_version"`
+	Summary        Summary   `json:"summary"`
 }
 
 type Summary struct {
@@ -42,6 +68,9 @@ type Summary struct {
 
 type SnapshotMetrics struct {
 	Time                time.Time
+	ProgramVersion      string
+	Username            string
+	Scope               Scope
 	BackupStart         time.Time
 	BackupEnd           time.Time
 	FilesNew            int
@@ -58,27 +87,226 @@ type SnapshotMetrics struct {
 	TotalBytesProcessed int
 }
 
-func readJson(data []byte) ([]GroupData, error) {
-	var o []GroupData
-	err := json.Unmarshal(data, &o)
+// decodeGroups decodes the output of restic snapshots --group-by incrementally from r.
+// Only the keep most recent snapshots of every group are retained, all snapshots are counted in GroupData.Count.
+// A keep of 0 retains all snapshots.
+func decodeGroups(r io.Reader, keep int) ([]GroupData, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return []GroupData{}, nil
+	}
+	if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected array of groups, got %v", tok)
+	}
+
+	groups := []GroupData{}
+	for dec.More() {
+		group, err := decodeGroup(dec, keep)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// decodeGroup decodes a single group object from dec.
+func decodeGroup(dec *json.Decoder, keep int) (GroupData, error) {
+	var group GroupData
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return group, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return group, err
+		}
+
+		switch tok {
+		case "group_key":
+			err = dec.Decode(&group.GroupKey)
+		case "snapshots":
+			err = decodeSnapshots(dec, &group, keep)
+		default:
+			var skipped json.RawMessage
+			err = dec.Decode(&skipped)
+		}
+		if err != nil {
+			return group, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return group, err
+	}
+
+	return group, nil
+}
+
+// decodeSnapshots decodes the snapshots array of a group and retains the keep most recent snapshots,
+// in their original order unless there are more than keep.
+func decodeSnapshots(dec *json.Decoder, group *GroupData, keep int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected array of snapshots, got %v", tok)
+	}
+
+	for dec.More() {
+		var snapshot Snapshot
+		if err := dec.Decode(&snapshot); err != nil {
+			return err
+		}
+		group.add(snapshot, keep)
+	}
+	group.unwrap()
+	_, err = dec.Token()
+	return err
+}
+
+// add counts snapshot in the group and retains it if it is one of the keep most recent snapshots.
+// Once keep snapshots are retained, Snapshots is a ring buffer sorted by time starting at head, so the chronological
+// output of restic replaces the oldest snapshot in constant time. unwrap restores a plain slice.
+func (g *GroupData) add(snapshot Snapshot, keep int) {
+	g.Count++
+	if keep <= 0 || len(g.Snapshots) < keep {
+		g.Snapshots = append(g.Snapshots, snapshot)
+		if len(g.Snapshots) == keep {
+			slices.SortStableFunc(g.Snapshots, byTime)
+		}
+		return
+	}
+
+	if snapshot.Time.Before(g.Snapshots[g.head].Time) {
+		// older than all retained snapshots
+		return
+	}
+
+	// replace the oldest snapshot, which makes snapshot the newest, and move it back if it isn't
+	g.Snapshots[g.head] = snapshot
+	g.head = (g.head + 1) % keep
+	for i := keep - 1; i > 0; i-- {
+		current, previous := (g.head+i)%keep, (g.head+i-1)%keep
+		if !g.Snapshots[current].Time.Before(g.Snapshots[previous].Time) {
+			break
+		}
+		g.Snapshots[current], g.Snapshots[previous] = g.Snapshots[previous], g.Snapshots[current]
+	}
+}
+
+// unwrap turns Snapshots back into a plain slice after add used it as a ring buffer.
+func (g *GroupData) unwrap() {
+	if g.head != 0 {
+		g.Snapshots = slices.Concat(g.Snapshots[g.head:], g.Snapshots[:g.head])
+		g.head = 0
+	}
+}
+
+func byTime(a, b Snapshot) int {
+	return a.Time.Compare(b.Time)
+}
+
+// decodeSnapshotList decodes the output of restic snapshots without --group-by incrementally from r and passes every snapshot to add.
+func decodeSnapshotList(r io.Reader, add func(Snapshot)) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected array of snapshots, got %v", tok)
+	}
+
+	for dec.More() {
+		var snapshot Snapshot
+		if err := dec.Decode(&snapshot); err != nil {
+			return err
+		}
+		add(snapshot)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// decodeSnapshotIDs reads the snapshot IDs printed by restic list snapshots, one per line.
+func decodeSnapshotIDs(r io.Reader) ([]string, error) {
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" {
+			continue
+		}
+		if !isSnapshotID(id) {
+			return nil, fmt.Errorf("invalid snapshot id %q", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+// isSnapshotID reports whether id is a full hex encoded snapshot ID.
+func isSnapshotID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// decodeSnapshot decodes the output of restic cat snapshot, which does not contain the snapshot ID.
+func decodeSnapshot(r io.Reader, id string) (Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.ID = id
+	snapshot.ShortID = id[:8]
+	return snapshot, nil
+}
+
+// expectDelim reads the next token from dec and fails if it is not delim.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
 	if err != nil {
-		return o, err
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
 	}
-	return o, nil
+	return nil
 }
 
 func getTotalSnapshotCount(data []GroupData) int {
 	sum := 0
 
 	for _, group := range data {
-		sum += len(group.Snapshots)
+		sum += group.SnapshotCount()
 	}
 
 	return sum
 }
 
 func getSnapshotCountByGroup(group GroupData) (GroupKey, int) {
-	return group.GroupKey, len(group.Snapshots)
+	return group.GroupKey, group.SnapshotCount()
 }
 
 func getLastSnapshotByGroup(group GroupData) (GroupKey, Snapshot, error) {
@@ -105,6 +333,9 @@ func getSnapshotMetricsByGroup(group GroupData) (GroupKey, SnapshotMetrics, erro
 
 	return group.GroupKey, SnapshotMetrics{
 		Time:                snapshot.Time,
+		ProgramVersion:      snapshot.ProgramVersion,
+		Username:            snapshot.Username,
+		Scope:               scopeOf(snapshot),
 		BackupStart:         snapshot.Summary.BackupStart,
 		BackupEnd:           snapshot.Summary.BackupEnd,
 		FilesNew:            snapshot.Summary.FilesNew,
@@ -1,13 +1,17 @@
 package snapshot
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
 
-func Test_readJson(t *testing.T) {
+func Test_decodeGroups(t *testing.T) {
 	type args struct {
 		testFileName string
 	}
@@ -27,9 +31,15 @@ func Test_readJson(t *testing.T) {
 					GroupKey: GroupKey{Hostname: "SK12", Tags: []string{"full-server"}},
 					Snapshots: []Snapshot{
 						{
-							Time:     mustParse(t, "2025-10-07T17:56:01.685056163+02:00"),
-							Hostname: "SK12",
-							Tags:     []string{"full-server"},
+							ID:             "96abef0e00a18ab45ace9f04dabdd5e5e6585cf8a1aac753ec9af3c756e5ac42",
+							ShortID:        "96abef0e",
+							Time:           mustParse(t, "2025-10-07T17:56:01.685056163+02:00"),
+							Paths:          []string{"/"},
+							Excludes:       []string{"/dev"},
+							Hostname:       "SK12",
+							Tags:           []string{"full-server"},
+							Username:       "root",
+							ProgramVersion: "restic 0.18.0",
 							Summary: Summary{
 								BackupStart:         mustParse(t, "2025-10-07T17:56:01.685056163+02:00"),
 								BackupEnd:           mustParse(t, "2025-10-07T18:02:13.257197421+02:00"),
@@ -48,9 +58,15 @@ func Test_readJson(t *testing.T) {
 							},
 						},
 						{
-							Time:     mustParse(t, "2025-10-12T00:35:01.347812525+02:00"),
-							Hostname: "SK12",
-							Tags:     []string{"full-server"},
+							ID:             "729e9fbbdce70718874e62d53397f31463105aedeffdc4babc3674776c631b80",
+							ShortID:        "729e9fbb",
+							Time:           mustParse(t, "2025-10-12T00:35:01.347812525+02:00"),
+							Paths:          []string{"/"},
+							Excludes:       []string{"/dev"},
+							Hostname:       "SK12",
+							Tags:           []string{"full-server"},
+							Username:       "root",
+							ProgramVersion: "restic 0.18.0",
 							Summary: Summary{
 								BackupStart:         mustParse(t, "2025-10-12T00:35:01.347812525+02:00"),
 								BackupEnd:           mustParse(t, "2025-10-12T00:36:10.861283523+02:00"),
@@ -69,14 +85,20 @@ func Test_readJson(t *testing.T) {
 							},
 						},
 					},
+					Count: 2,
 				},
 				{
 					GroupKey: GroupKey{Hostname: "SK12", Tags: []string{"kuma"}},
 					Snapshots: []Snapshot{
 						{
-							Time:     mustParse(t, "2025-10-08T05:23:10.031203027+02:00"),
-							Hostname: "SK12",
-							Tags:     []string{"kuma"},
+							ID:             "c9719c8941be0587617fe31977d8b8809c1897c000225150e37126f741ef5b8c",
+							ShortID:        "c9719c89",
+							Time:           mustParse(t, "2025-10-08T05:23:10.031203027+02:00"),
+							Paths:          []string{"/root/kuma"},
+							Hostname:       "SK12",
+							Tags:           []string{"kuma"},
+							Username:       "root",
+							ProgramVersion: "restic 0.18.0",
 							Summary: Summary{
 								BackupStart:         mustParse(t, "2025-10-08T05:23:10.031203027+02:00"),
 								BackupEnd:           mustParse(t, "2025-10-08T05:23:26.623964395+02:00"),
@@ -95,9 +117,14 @@ func Test_readJson(t *testing.T) {
 							},
 						},
 						{
-							Time:     mustParse(t, "2025-10-12T05:23:09.346002024+02:00"),
-							Hostname: "SK12",
-							Tags:     []string{"kuma"},
+							ID:             "4e66c0cc6b3911b64dbf7fba48d9283dcc2d9bb198cdc608c8c25cc6b0c08046",
+							ShortID:        "4e66c0cc",
+							Time:           mustParse(t, "2025-10-12T05:23:09.346002024+02:00"),
+							Paths:          []string{"/root/kuma"},
+							Hostname:       "SK12",
+							Tags:           []string{"kuma"},
+							Username:       "root",
+							ProgramVersion: "restic 0.18.0",
 							Summary: Summary{
 								BackupStart:         mustParse(t, "2025-10-12T05:23:09.346002024+02:00"),
 								BackupEnd:           mustParse(t, "2025-10-12T05:23:24.842472768+02:00"),
@@ -116,6 +143,7 @@ func Test_readJson(t *testing.T) {
 							},
 						},
 					},
+					Count: 2,
 				},
 			},
 			wantErr: false,
@@ -139,22 +167,189 @@ func Test_readJson(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data, err := os.ReadFile(tt.args.testFileName)
+			f, err := os.Open(tt.args.testFileName)
 			if err != nil {
-				t.Fatalf("readJson test file: %v", err)
+				t.Fatalf("open test file: %v", err)
 			}
-			got, err := readJson(data)
+			defer f.Close()
+
+			got, err := decodeGroups(f, 0)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("readJson() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("decodeGroups() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("readJson() got = %v, want %v", got, tt.want)
+				t.Errorf("decodeGroups() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeGroups_RetainsMostRecent(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeGroups(w, 2, 1000))
+	}()
+
+	got, err := decodeGroups(r, 10)
+	if err != nil {
+		t.Fatalf("decodeGroups() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("decodeGroups() got %d groups, want 2", len(got))
+	}
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, group := range got {
+		if group.Count != 1000 || group.SnapshotCount() != 1000 {
+			t.Errorf("group %v: Count = %d, SnapshotCount() = %d, want 1000", group.GroupKey, group.Count, group.SnapshotCount())
+		}
+		if len(group.Snapshots) != 10 {
+			t.Fatalf("group %v: retained %d snapshots, want 10", group.GroupKey, len(group.Snapshots))
+		}
+		for i, s := range group.Snapshots {
+			if want := base.Add(time.Duration(990+i) * time.Hour); !s.Time.Equal(want) {
+				t.Errorf("group %v: snapshot %d time = %v, want %v", group.GroupKey, i, s.Time, want)
+			}
+		}
+	}
+}
+
+func Test_decodeGroups_BoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("decodes 60000 snapshots")
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeGroups(w, 10, 6000))
+	}()
+
+	got, err := decodeGroups(r, DefaultSnapshotHistory)
+	if err != nil {
+		t.Fatalf("decodeGroups() error = %v", err)
+	}
+	for _, group := range got {
+		if group.Count != 6000 {
+			t.Errorf("group %v: Count = %d, want 6000", group.GroupKey, group.Count)
+		}
+		// the ring buffer never grows beyond the history, so the memory retained per group is bounded
+		if len(group.Snapshots) != DefaultSnapshotHistory || cap(group.Snapshots) > 2*DefaultSnapshotHistory {
+			t.Errorf("group %v: retained %d snapshots with capacity %d, want %d", group.GroupKey, len(group.Snapshots), cap(group.Snapshots), DefaultSnapshotHistory)
+		}
+	}
+}
+
+func TestGroupData_add_OutOfOrder(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var group GroupData
+	for _, hour := range []int{5, 1, 9, 3, 7, 2, 8, 6, 4, 0} {
+		group.add(Snapshot{Time: base.Add(time.Duration(hour) * time.Hour)}, 4)
+	}
+	group.unwrap()
+
+	if group.Count != 10 {
+		t.Errorf("Count = %d, want 10", group.Count)
+	}
+	var got []int
+	for _, s := range group.Snapshots {
+		got = append(got, int(s.Time.Sub(base).Hours()))
+	}
+	if want := []int{6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("retained hours = %v, want %v", got, want)
+	}
+}
+
+func Test_decodeGroups_Null(t *testing.T) {
+	got, err := decodeGroups(strings.NewReader("null"), DefaultSnapshotHistory)
+	if err != nil {
+		t.Fatalf("decodeGroups() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []GroupData{}) {
+		t.Errorf("decodeGroups() got = %v, want empty", got)
+	}
+}
+
+// BenchmarkDecodeGroups decodes generated restic snapshots output of increasing size.
+// The retained-B metric is the heap still in use by the decoded groups and must not grow with the number of snapshots.
+func BenchmarkDecodeGroups(b *testing.B) {
+	for _, snapshots := range []int{1000, 10000, 60000} {
+		b.Run(fmt.Sprintf("snapshots=%d", snapshots), func(b *testing.B) {
+			b.ReportAllocs()
+
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				r, w := io.Pipe()
+				go func() {
+					w.CloseWithError(writeGroups(w, 10, snapshots/10))
+				}()
+
+				var before, after runtime.MemStats
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				b.StartTimer()
+
+				groups, err := decodeGroups(r, DefaultSnapshotHistory)
+				if err != nil {
+					b.Fatalf("decodeGroups() error = %v", err)
+				}
+
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(groups)
+				b.StartTimer()
+				if after.HeapAlloc > before.HeapAlloc {
+					retained = after.HeapAlloc - before.HeapAlloc
+				}
 			}
+			b.ReportMetric(float64(retained), "retained-B")
 		})
 	}
 }
 
+// writeGroups writes restic snapshots --group-by output with groups groups of perGroup hourly snapshots to w.
+// The output is generated while it is read, so large outputs are never held in memory.
+func writeGroups(w io.Writer, groups, perGroup int) error {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for g := 0; g < groups; g++ {
+		if g > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		hostname := fmt.Sprintf("host%d", g)
+		if _, err := fmt.Fprintf(w, `{"group_key":{"hostname":%q,"paths":null,"tags":["daily"]},"snapshots":[`, hostname); err != nil {
+			return err
+		}
+		for i := 0; i < perGroup; i++ {
+			if i > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			start := base.Add(time.Duration(i) * time.Hour).Format(time.RFC3339Nano)
+			_, err := fmt.Fprintf(w, `{"time":%q,"tree":"%064x","paths":["/"],"hostname":%q,"username":"root","tags":["daily"],"program_version":"restic 0.18.0",`+
+				`"summary":{"backup_start":%q,"backup_end":%q,"files_new":1,"files_changed":2,"files_unmodified":3,"dirs_new":4,"dirs_changed":5,"dirs_unmodified":6,`+
+				`"data_blobs":7,"tree_blobs":8,"data_added":9,"data_added_packed":10,"total_files_processed":11,"total_bytes_processed":12},"id":"%064x","short_id":"%08x"}`,
+				start, i, hostname, start, start, g*perGroup+i, g*perGroup+i)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "]}"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
 // mustParse parses an RFC3339Nano string into time.Time and fails the test on error.
 // Use t.Helper() to trace errors back to the caller.
 func mustParse(t testing.TB, s string) time.Time {
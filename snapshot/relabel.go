@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"cmp"
+	"strings"
+)
+
+// Relabeler rewrites a group before its metrics are exported. It returns false to drop the group.
+// A Relabeler must not modify the snapshots of group, they are shared with the cached result.
+type Relabeler func(group GroupData) (GroupData, bool)
+
+// exportedGroups applies relabel and the tag sorting to groups and merges the groups that end up with the same labels,
+// so every series is exported once. dropped holds the groups dropped by relabel.
+func exportedGroups(groups []GroupData, relabel Relabeler, sortTags bool) (exported, dropped []GroupData) {
+	byLabels := map[string]int{}
+	for _, group := range groups {
+		if relabel != nil {
+			relabeled, ok := relabel(group)
+			if !ok {
+				dropped = append(dropped, group)
+				continue
+			}
+			group = relabeled
+		}
+		if sortTags {
+			group.GroupKey.Tags = sortedCopy(group.GroupKey.Tags)
+		}
+
+		labels := group.GroupKey.Hostname + "\x00" + strings.Join(group.GroupKey.Tags, ",")
+		i, ok := byLabels[labels]
+		if !ok {
+			byLabels[labels] = len(exported)
+			exported = append(exported, group)
+			continue
+		}
+
+		merged := &exported[i]
+		merged.Count = merged.SnapshotCount() + group.SnapshotCount()
+		merged.Snapshots = append(append([]Snapshot(nil), merged.Snapshots...), group.Snapshots...)
+	}
+	return exported, dropped
+}
+
+// compareLabels orders groups by their hostname and then by their joined tags, the values of their labels.
+func compareLabels(a, b GroupData) int {
+	return cmp.Or(
+		strings.Compare(a.GroupKey.Hostname, b.GroupKey.Hostname),
+		strings.Compare(strings.Join(a.GroupKey.Tags, ","), strings.Join(b.GroupKey.Tags, ",")),
+	)
+}
@@ -1,28 +1,68 @@
 package snapshot
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"restic-stats-exporter/status"
 	"restic-stats-exporter/util"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Collector struct {
 	resticExecutablePath string
-	commandExecutor      util.CommandExecutor
+	commandExecutor      util.StreamCommandExecutor
+	status               *status.Repository
+	parseErrors          prometheus.Counter
+
+	cache util.Cache[result]
+
+	mu          sync.Mutex
+	incremental bool
+	sortTags    bool
+	tagSeries   bool
+	// scopes holds the scopes passed to ExpectScope.
+	scopes []expectedScope
+	// expectedScopes holds the scopes relabeled like the groups by GroupKey.id of the relabeled group.
+	expectedScopes map[string]Scope
+	anomaly        AnomalyThresholds
+	relabel        Relabeler
+	maxGroups      int
+
+	// indexMu serializes incremental refreshes, which update index.
+	indexMu sync.Mutex
+	index   *index
+}
+
+// result holds the outcome of a single restic snapshots invocation.
+type result struct {
+	exitCode  int
+	groupData []GroupData
 }
 
-func NewSnapshotCollector(resticExecutablePath string) *Collector {
+func NewSnapshotCollector(resticExecutablePath string, commandExecutor util.StreamCommandExecutor, repositoryStatus *status.Repository, parseErrors prometheus.Counter) *Collector {
 	return &Collector{
 		resticExecutablePath: resticExecutablePath,
-		commandExecutor:      util.ExecCommandExecutor,
+		commandExecutor:      commandExecutor,
+		status:               repositoryStatus,
+		parseErrors:          parseErrors,
 	}
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- snapshotCountTotalDesc
 	ch <- snapshotCountDesc
+	ch <- groupTagDesc
 	ch <- lastSnapshotTimeDesc
+	ch <- lastSnapshotInfoDesc
 	ch <- lastSnapshotBackupStartDesc
 	ch <- lastSnapshotBackupEndDesc
 	ch <- lastSnapshotFilesNewDesc
@@ -37,60 +77,397 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- lastSnapshotDataAddedPackedDesc
 	ch <- lastSnapshotTotalFilesProcessedDesc
 	ch <- lastSnapshotTotalBytesProcessedDesc
+	ch <- lastSnapshotScopeChangedDesc
+	ch <- lastSnapshotScopeUnexpectedDesc
+	ch <- lastSnapshotBytesProcessedRatioDesc
+	ch <- lastSnapshotFilesProcessedRatioDesc
+	ch <- lastSnapshotAnomalousDesc
+	ch <- droppedSeriesDesc
+	ch <- groupsOverflowDesc
+	ch <- cacheAgeDesc
 	ch <- snapshotExitCode
 }
 
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	out, err, exitCode := c.commandExecutor(c.resticExecutablePath, "snapshots", "--json", "--no-lock", "--group-by", "host,tags")
+// Run refreshes the snapshot data every interval until ctx is cancelled, Collect serves the last result meanwhile.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	c.cache.Run(ctx, interval, c.refresh, func(next time.Time) {
+		if c.status != nil {
+			c.status.SetNextRun(next)
+		}
+	})
+}
+
+// LoadIncrementally makes the collector remember the snapshots of previous refreshes. Instead of listing all snapshots,
+// a refresh then detects added and removed snapshots with restic list snapshots and loads only the added ones with restic cat.
+func (c *Collector) LoadIncrementally() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.incremental = true
+}
+
+// SortTags makes the collector sort the tags of a group before joining them for the restic_tags label,
+// so the label value doesn't depend on the order restic reports the tags in.
+func (c *Collector) SortTags() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sortTags = true
+}
+
+// ExportTagSeries makes the collector export a restic_group_tag series for every tag of every group.
+func (c *Collector) ExportTagSeries() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tagSeries = true
+}
+
+// Relabel makes the collector rewrite or drop every group with relabel before exporting its metrics.
+func (c *Collector) Relabel(relabel Relabeler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.relabel = relabel
+	c.expectedScopes = relabeledScopes(c.scopes, relabel)
+}
+
+// LimitGroups makes the collector export the metrics of at most max groups, the first ones by hostname and tags.
+// Further groups are only counted in restic_snapshot_groups_overflow.
+func (c *Collector) LimitGroups(max int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxGroups = max
+}
+
+// ExpectScope makes the collector report whether the last snapshot of the group with hostname and tags was created with scope.
+// hostname, tags and the paths of scope are relabeled like the groups before they are compared.
+func (c *Collector) ExpectScope(hostname string, tags []string, scope Scope) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scopes = append(c.scopes, expectedScope{key: GroupKey{Hostname: hostname, Tags: tags}, scope: scope})
+	c.expectedScopes = relabeledScopes(c.scopes, c.relabel)
+}
+
+// DetectAnomalies makes the collector compare the bytes and files processed by the last snapshot of every group
+// to the median of the snapshots before.
+func (c *Collector) DetectAnomalies(thresholds AnomalyThresholds) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.anomaly = thresholds
+}
+
+// refresh lists the snapshots with restic and records the outcome in the repository status.
+func (c *Collector) refresh(ctx context.Context) (result, bool) {
+	c.mu.Lock()
+	incremental := c.incremental
+	c.mu.Unlock()
+
+	start := time.Now()
+	var groupData []GroupData
+	var err error
+	var exitCode int
+	if incremental {
+		groupData, err, exitCode = c.loadIncremental(ctx)
+	} else {
+		groupData, err, exitCode = c.load(ctx)
+	}
 
+	if errors.Is(err, util.ErrInvalidOutput) {
+		if c.parseErrors != nil {
+			c.parseErrors.Inc()
+		}
+		c.record(start, util.JSONParseErrorExitCode, err.Error())
+		return result{exitCode: util.JSONParseErrorExitCode}, false
+	}
 	if err != nil {
-		ch <- prometheus.MustNewConstMetric(snapshotExitCode, prometheus.GaugeValue, float64(exitCode))
-		return
+		c.record(start, exitCode, stderrOf(err))
+		return result{exitCode: exitCode}, false
+	}
+
+	c.record(start, exitCode, "")
+	return result{exitCode: exitCode, groupData: groupData}, true
+}
+
+// load lists all snapshots grouped by host and tags.
+func (c *Collector) load(ctx context.Context) ([]GroupData, error, int) {
+	decode := func(stdout io.Reader) (any, error) {
+		return decodeGroups(stdout, DefaultSnapshotHistory)
 	}
+	out, err, exitCode := c.commandExecutor(ctx, decode, c.resticExecutablePath, "snapshots", "--json", "--no-lock", "--group-by", "host,tags")
+	if err != nil {
+		return nil, err, exitCode
+	}
+	return out.([]GroupData), nil, exitCode
+}
+
+// loadIncremental updates the index with the snapshots added and removed since the last refresh.
+// The index is built by listing all snapshots on the first refresh and whenever it can't be updated incrementally.
+func (c *Collector) loadIncremental(ctx context.Context) ([]GroupData, error, int) {
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
 
-	groupData, err := readJson(out)
+	if c.index == nil {
+		return c.loadIndex(ctx)
+	}
+
+	decodeIDs := func(stdout io.Reader) (any, error) {
+		return decodeSnapshotIDs(stdout)
+	}
+	out, err, exitCode := c.commandExecutor(ctx, decodeIDs, c.resticExecutablePath, "list", "snapshots", "--no-lock")
 	if err != nil {
-		ch <- prometheus.MustNewConstMetric(snapshotExitCode, prometheus.GaugeValue, float64(1684))
+		return nil, err, exitCode
+	}
+	ids := out.([]string)
+
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = true
+	}
+	for _, id := range c.index.ids() {
+		if listed[id] {
+			continue
+		}
+		if !c.index.remove(id) {
+			c.index = nil
+			return c.loadIndex(ctx)
+		}
+	}
+
+	var added []Snapshot
+	for _, id := range ids {
+		if c.index.contains(id) {
+			continue
+		}
+		decode := func(stdout io.Reader) (any, error) {
+			return decodeSnapshot(stdout, id)
+		}
+		out, err, exitCode = c.commandExecutor(ctx, decode, c.resticExecutablePath, "cat", "snapshot", id, "--no-lock")
+		if err != nil {
+			return nil, err, exitCode
+		}
+		added = append(added, out.(Snapshot))
+	}
+
+	slices.SortStableFunc(added, func(a, b Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	for _, snapshot := range added {
+		c.index.add(snapshot)
+	}
+
+	return c.index.groupData(), nil, exitCode
+}
+
+// loadIndex builds the index from the list of all snapshots.
+func (c *Collector) loadIndex(ctx context.Context) ([]GroupData, error, int) {
+	decode := func(stdout io.Reader) (any, error) {
+		idx := newIndex(DefaultSnapshotHistory)
+		return idx, decodeSnapshotList(stdout, idx.add)
+	}
+	out, err, exitCode := c.commandExecutor(ctx, decode, c.resticExecutablePath, "snapshots", "--json", "--no-lock")
+	if err != nil {
+		return nil, err, exitCode
+	}
+
+	switch out := out.(type) {
+	case *index:
+		c.index = out
+	case []Snapshot:
+		// listed without restic by the native reader
+		c.index = newIndex(DefaultSnapshotHistory)
+		for _, snapshot := range out {
+			c.index.add(snapshot)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unexpected result %T", util.ErrInvalidOutput, out), exitCode
+	}
+	return c.index.groupData(), nil, exitCode
+}
+
+// Restore seeds the collector with the snapshot groups of a previous successful refresh.
+func (c *Collector) Restore(groupData []GroupData, refreshedAt time.Time) {
+	c.cache.Restore(result{groupData: groupData}, refreshedAt)
+}
+
+// GroupData returns the snapshot groups of the last successful refresh and the time it started.
+func (c *Collector) GroupData() (groupData []GroupData, refreshedAt time.Time, ok bool) {
+	res, ok := c.cache.LastSuccess()
+	return res.Value.groupData, res.RefreshedAt, ok
+}
+
+func (c *Collector) record(start time.Time, exitCode int, stderr string) {
+	if c.status == nil {
+		return
+	}
+	c.status.Record(start, time.Since(start), exitCode, stderr)
+}
+
+// stderrOf returns the error messages restic wrote to stderr or the error message if there are none.
+// Other output is dropped, it may come from a password command and contain secrets.
+func stderrOf(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if messages := resticErrors(exitErr.Stderr); messages != "" {
+			return messages
+		}
+	}
+	return err.Error()
+}
+
+// resticErrors returns the fatal errors in the stderr output of restic, printed as text or as exit_error message.
+func resticErrors(stderr []byte) string {
+	var messages []string
+	for line := range strings.Lines(string(stderr)) {
+		line = strings.TrimSpace(line)
+		var exitError struct {
+			MessageType string `json:"message_type"`
+			Message     string `json:"message"`
+		}
+		if json.Unmarshal([]byte(line), &exitError) == nil && exitError.MessageType == "exit_error" {
+			line = strings.TrimSpace(exitError.Message)
+		}
+		if strings.HasPrefix(line, "Fatal: ") {
+			messages = append(messages, line)
+		}
+	}
+	return strings.Join(messages, "\n")
+}
+
+// collectOptions holds the settings of the collector that affect the exported metrics.
+type collectOptions struct {
+	sortTags       bool
+	tagSeries      bool
+	expectedScopes map[string]Scope
+	anomaly        AnomalyThresholds
+	relabel        Relabeler
+	maxGroups      int
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	opts := collectOptions{
+		sortTags:       c.sortTags,
+		tagSeries:      c.tagSeries,
+		expectedScopes: c.expectedScopes,
+		anomaly:        c.anomaly,
+		relabel:        c.relabel,
+		maxGroups:      c.maxGroups,
+	}
+	c.mu.Unlock()
+
+	res, ok := c.cache.Current(context.Background(), c.refresh)
+	if !ok {
+		// the first refresh is still running
 		return
 	}
-	ch <- prometheus.MustNewConstMetric(snapshotExitCode, prometheus.GaugeValue, float64(exitCode))
 
-	totalSnapshotCount := getTotalSnapshotCount(groupData)
+	ch <- prometheus.MustNewConstMetric(snapshotExitCode, prometheus.GaugeValue, float64(res.Value.exitCode))
+	if !res.OK {
+		return
+	}
+
+	if res.Cached {
+		ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(res.RefreshedAt).Seconds())
+	}
+
+	totalSnapshotCount := getTotalSnapshotCount(res.Value.groupData)
 	ch <- prometheus.MustNewConstMetric(snapshotCountTotalDesc, prometheus.GaugeValue, float64(totalSnapshotCount))
 
-	for _, group := range groupData {
-		hostname := group.GroupKey.Hostname
-		tags := strings.Join(group.GroupKey.Tags, ",")
-		_, snapshotCount := getSnapshotCountByGroup(group)
-		ch <- prometheus.MustNewConstMetric(
-			snapshotCountDesc,
-			prometheus.GaugeValue,
-			float64(snapshotCount),
-			hostname,
-			tags,
-		)
-
-		if snapshotCount > 0 {
-			_, metrics, err := getSnapshotMetricsByGroup(group)
-			if err != nil {
-				panic(err)
-			}
-
-			ch <- prometheus.MustNewConstMetric(lastSnapshotTimeDesc, prometheus.GaugeValue, float64(metrics.Time.Unix()), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotBackupStartDesc, prometheus.GaugeValue, float64(metrics.BackupStart.Unix()), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotBackupEndDesc, prometheus.GaugeValue, float64(metrics.BackupEnd.Unix()), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotFilesNewDesc, prometheus.GaugeValue, float64(metrics.FilesNew), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotFilesChangedDesc, prometheus.GaugeValue, float64(metrics.FilesChanged), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotFilesUnmodifiedDesc, prometheus.GaugeValue, float64(metrics.FilesUnmodified), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDirsNewDesc, prometheus.GaugeValue, float64(metrics.DirsNew), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDirsChangedDesc, prometheus.GaugeValue, float64(metrics.DirsChanged), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDirsUnmodifiedDesc, prometheus.GaugeValue, float64(metrics.DirsUnmodified), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDataBlobsDesc, prometheus.GaugeValue, float64(metrics.DataBlobs), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotTreeBlobsDesc, prometheus.GaugeValue, float64(metrics.TreeBlobs), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDataAddedDesc, prometheus.GaugeValue, float64(metrics.DataAdded), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotDataAddedPackedDesc, prometheus.GaugeValue, float64(metrics.DataAddedPacked), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotTotalFilesProcessedDesc, prometheus.GaugeValue, float64(metrics.TotalFilesProcessed), hostname, tags)
-			ch <- prometheus.MustNewConstMetric(lastSnapshotTotalBytesProcessedDesc, prometheus.GaugeValue, float64(metrics.TotalBytesProcessed), hostname, tags)
+	emit := func(m prometheus.Metric) { ch <- m }
+	groups, dropped := exportedGroups(res.Value.groupData, opts.relabel, opts.sortTags)
+	var overflow []GroupData
+	if opts.maxGroups > 0 && len(groups) > opts.maxGroups {
+		// restic lists the groups in random order
+		slices.SortFunc(groups, compareLabels)
+		groups, overflow = groups[:opts.maxGroups], groups[opts.maxGroups:]
+	}
+	for _, group := range groups {
+		collectGroup(emit, group, opts)
+	}
+
+	if opts.relabel == nil && opts.maxGroups == 0 {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(droppedSeriesDesc, prometheus.GaugeValue, float64(countSeries(dropped, opts)), "relabel")
+	ch <- prometheus.MustNewConstMetric(droppedSeriesDesc, prometheus.GaugeValue, float64(countSeries(overflow, opts)), "limit")
+	ch <- prometheus.MustNewConstMetric(groupsOverflowDesc, prometheus.GaugeValue, float64(len(overflow)))
+}
+
+// countSeries returns the number of series collectGroup exports for groups.
+func countSeries(groups []GroupData, opts collectOptions) int {
+	n := 0
+	count := func(prometheus.Metric) { n++ }
+	for _, group := range groups {
+		collectGroup(count, group, opts)
+	}
+	return n
+}
+
+// collectGroup passes the metrics of group to emit.
+func collectGroup(emit func(prometheus.Metric), group GroupData, opts collectOptions) {
+	hostname := group.GroupKey.Hostname
+	tags := strings.Join(group.GroupKey.Tags, ",")
+	if opts.tagSeries {
+		for _, tag := range group.GroupKey.Tags {
+			emit(prometheus.MustNewConstMetric(groupTagDesc, prometheus.GaugeValue, 1, hostname, tags, tag))
 		}
 	}
+	_, snapshotCount := getSnapshotCountByGroup(group)
+	emit(prometheus.MustNewConstMetric(
+		snapshotCountDesc,
+		prometheus.GaugeValue,
+		float64(snapshotCount),
+		hostname,
+		tags,
+	))
+
+	if snapshotCount == 0 {
+		return
+	}
+
+	_, metrics, err := getSnapshotMetricsByGroup(group)
+	if err != nil {
+		panic(err)
+	}
+
+	emit(prometheus.MustNewConstMetric(lastSnapshotTimeDesc, prometheus.GaugeValue, float64(metrics.Time.Unix()), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotInfoDesc, prometheus.GaugeValue, 1, hostname, tags, metrics.ProgramVersion, metrics.Username))
+	emit(prometheus.MustNewConstMetric(lastSnapshotBackupStartDesc, prometheus.GaugeValue, float64(metrics.BackupStart.Unix()), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotBackupEndDesc, prometheus.GaugeValue, float64(metrics.BackupEnd.Unix()), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotFilesNewDesc, prometheus.GaugeValue, float64(metrics.FilesNew), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotFilesChangedDesc, prometheus.GaugeValue, float64(metrics.FilesChanged), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotFilesUnmodifiedDesc, prometheus.GaugeValue, float64(metrics.FilesUnmodified), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDirsNewDesc, prometheus.GaugeValue, float64(metrics.DirsNew), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDirsChangedDesc, prometheus.GaugeValue, float64(metrics.DirsChanged), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDirsUnmodifiedDesc, prometheus.GaugeValue, float64(metrics.DirsUnmodified), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDataBlobsDesc, prometheus.GaugeValue, float64(metrics.DataBlobs), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotTreeBlobsDesc, prometheus.GaugeValue, float64(metrics.TreeBlobs), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDataAddedDesc, prometheus.GaugeValue, float64(metrics.DataAdded), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotDataAddedPackedDesc, prometheus.GaugeValue, float64(metrics.DataAddedPacked), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotTotalFilesProcessedDesc, prometheus.GaugeValue, float64(metrics.TotalFilesProcessed), hostname, tags))
+	emit(prometheus.MustNewConstMetric(lastSnapshotTotalBytesProcessedDesc, prometheus.GaugeValue, float64(metrics.TotalBytesProcessed), hostname, tags))
+
+	emit(prometheus.MustNewConstMetric(lastSnapshotScopeChangedDesc, prometheus.GaugeValue, boolToFloat(scopeChanged(group)), hostname, tags))
+	if expected, ok := opts.expectedScopes[GroupKey{Hostname: hostname, Tags: sortedCopy(group.GroupKey.Tags)}.id()]; ok {
+		unexpected := metrics.Scope.Fingerprint() != expected.Fingerprint()
+		emit(prometheus.MustNewConstMetric(lastSnapshotScopeUnexpectedDesc, prometheus.GaugeValue, boolToFloat(unexpected), hostname, tags))
+	}
+
+	if ratios, ok := processedRatiosOf(group, opts.anomaly.Window); ok {
+		emit(prometheus.MustNewConstMetric(lastSnapshotBytesProcessedRatioDesc, prometheus.GaugeValue, ratios.Bytes, hostname, tags))
+		emit(prometheus.MustNewConstMetric(lastSnapshotFilesProcessedRatioDesc, prometheus.GaugeValue, ratios.Files, hostname, tags))
+		emit(prometheus.MustNewConstMetric(lastSnapshotAnomalousDesc, prometheus.GaugeValue, boolToFloat(ratios.anomalous(opts.anomaly)), hostname, tags))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
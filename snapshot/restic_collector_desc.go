@@ -14,12 +14,24 @@ var (
 		[]string{"restic_hostname", "restic_tags"}, nil,
 	)
 
+	groupTagDesc = prometheus.NewDesc(
+		"restic_group_tag",
+		"Tags of the snapshot groups, one series per tag to select groups containing a tag",
+		[]string{"restic_hostname", "restic_tags", "tag"}, nil,
+	)
+
 	lastSnapshotTimeDesc = prometheus.NewDesc(
 		"restic_last_snapshot_time_seconds",
 		"Unix timestamp of the last snapshot",
 		[]string{"restic_hostname", "restic_tags"}, nil,
 	)
 
+	lastSnapshotInfoDesc = prometheus.NewDesc(
+		"restic_last_snapshot_info",
+		"Information about the last snapshot: restic version and user that created it",
+		[]string{"restic_hostname", "restic_tags", "program_version", "username"}, nil,
+	)
+
 	lastSnapshotBackupStartDesc = prometheus.NewDesc(
 		"restic_last_snapshot_backup_start_seconds",
 		"Unix timestamp: start time of the last backup",
@@ -104,6 +116,54 @@ var (
 		[]string{"restic_hostname", "restic_tags"}, nil,
 	)
 
+	lastSnapshotScopeChangedDesc = prometheus.NewDesc(
+		"restic_last_snapshot_scope_changed",
+		"Whether the paths or excludes of the last snapshot differ from the ones of the snapshot before",
+		[]string{"restic_hostname", "restic_tags"}, nil,
+	)
+
+	lastSnapshotScopeUnexpectedDesc = prometheus.NewDesc(
+		"restic_last_snapshot_scope_unexpected",
+		"Whether the paths or excludes of the last snapshot differ from the configured ones, only exported for groups with configured paths",
+		[]string{"restic_hostname", "restic_tags"}, nil,
+	)
+
+	lastSnapshotBytesProcessedRatioDesc = prometheus.NewDesc(
+		"restic_last_snapshot_bytes_processed_ratio",
+		"Total bytes processed in the last snapshot relative to the median of the snapshots before",
+		[]string{"restic_hostname", "restic_tags"}, nil,
+	)
+
+	lastSnapshotFilesProcessedRatioDesc = prometheus.NewDesc(
+		"restic_last_snapshot_files_processed_ratio",
+		"Total files processed in the last snapshot relative to the median of the snapshots before",
+		[]string{"restic_hostname", "restic_tags"}, nil,
+	)
+
+	lastSnapshotAnomalousDesc = prometheus.NewDesc(
+		"restic_last_snapshot_anomalous",
+		"Whether the bytes or files processed in the last snapshot are outside the configured ratios to the median of the snapshots before",
+		[]string{"restic_hostname", "restic_tags"}, nil,
+	)
+
+	droppedSeriesDesc = prometheus.NewDesc(
+		"restic_snapshot_dropped_series",
+		"Number of group series not exported, by reason: relabel for groups dropped by relabel rules, limit for groups over max_groups",
+		[]string{"reason"}, nil,
+	)
+
+	groupsOverflowDesc = prometheus.NewDesc(
+		"restic_snapshot_groups_overflow",
+		"Number of groups not exported because the repository had more groups than max_groups",
+		nil, nil,
+	)
+
+	cacheAgeDesc = prometheus.NewDesc(
+		"restic_snapshot_cache_age_seconds",
+		"Seconds since the served snapshot data was refreshed, only exported when refreshing in the background",
+		nil, nil,
+	)
+
 	snapshotExitCode = prometheus.NewDesc("restic_snapshot_exit_code",
 		"Exit code of the list snapshots command. See restic exit codes, except 1684 for json output parsing errors: "+
 			"https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes",
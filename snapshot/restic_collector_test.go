@@ -1,9 +1,17 @@
 package snapshot
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
+	"reflect"
+	"regexp"
+	"restic-stats-exporter/status"
+	"restic-stats-exporter/util"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -20,7 +28,9 @@ func TestCollector_Describe(t *testing.T) {
 	expectedDesc := map[string]bool{
 		snapshotCountTotalDesc.String():              true,
 		snapshotCountDesc.String():                   true,
+		groupTagDesc.String():                        true,
 		lastSnapshotTimeDesc.String():                true,
+		lastSnapshotInfoDesc.String():                true,
 		lastSnapshotBackupStartDesc.String():         true,
 		lastSnapshotBackupEndDesc.String():           true,
 		lastSnapshotFilesNewDesc.String():            true,
@@ -35,6 +45,14 @@ func TestCollector_Describe(t *testing.T) {
 		lastSnapshotDataAddedPackedDesc.String():     true,
 		lastSnapshotTotalFilesProcessedDesc.String(): true,
 		lastSnapshotTotalBytesProcessedDesc.String(): true,
+		lastSnapshotScopeChangedDesc.String():        true,
+		lastSnapshotScopeUnexpectedDesc.String():     true,
+		lastSnapshotBytesProcessedRatioDesc.String(): true,
+		lastSnapshotFilesProcessedRatioDesc.String(): true,
+		lastSnapshotAnomalousDesc.String():           true,
+		droppedSeriesDesc.String():                   true,
+		groupsOverflowDesc.String():                  true,
+		cacheAgeDesc.String():                        true,
 		snapshotExitCode.String():                    true,
 	}
 
@@ -107,13 +125,13 @@ func TestCollector_Collect_VariousResticExitCodes(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// fakeExec returns the specified exit code
-			fakeExec := func(exe string, args ...string) ([]byte, error, int) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
 				return []byte(``), errors.New("error for unit test"), tt.fields.exitCode
 			}
 
 			c := &Collector{
 				resticExecutablePath: "",
-				commandExecutor:      fakeExec,
+				commandExecutor:      util.Streaming(fakeExec),
 			}
 
 			reg := prometheus.NewRegistry()
@@ -160,13 +178,13 @@ func TestCollector_Collect_InvalidJsonOutput(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// fakeExec returns the specified JSON output as bytes
-			fakeExec := func(exe string, args ...string) ([]byte, error, int) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
 				return []byte(tt.fields.json), nil, 0
 			}
 
 			c := &Collector{
 				resticExecutablePath: "",
-				commandExecutor:      fakeExec,
+				commandExecutor:      util.Streaming(fakeExec),
 			}
 
 			reg := prometheus.NewRegistry()
@@ -219,7 +237,7 @@ func TestCollector_Collect_ExecutablePath(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// fakeExec check if the executable path is as expected and return empty JSON array output
-			fakeExec := func(exe string, args ...string) ([]byte, error, int) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
 				if exe != tt.fields.resticExecutablePath {
 					t.Fatalf("unexpected executable: %s", exe)
 				}
@@ -228,7 +246,7 @@ func TestCollector_Collect_ExecutablePath(t *testing.T) {
 
 			c := &Collector{
 				resticExecutablePath: tt.fields.resticExecutablePath,
-				commandExecutor:      fakeExec,
+				commandExecutor:      util.Streaming(fakeExec),
 			}
 
 			reg := prometheus.NewRegistry()
@@ -256,13 +274,13 @@ restic_snapshot_count_total 0
 
 func TestCollector_Collect_No_Snapshot(t *testing.T) {
 	// fakeExec returns the specified JSON output with zero snapshots
-	fakeExec := func(exe string, args ...string) ([]byte, error, int) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
 		return []byte(`[]`), nil, 0
 	}
 
 	c := &Collector{
 		resticExecutablePath: "restic",
-		commandExecutor:      fakeExec,
+		commandExecutor:      util.Streaming(fakeExec),
 	}
 
 	reg := prometheus.NewRegistry()
@@ -287,7 +305,7 @@ restic_snapshot_count_total 0
 
 func TestCollector_Collect_Multiple_Groups(t *testing.T) {
 	// fakeExec returns the specified JSON output
-	fakeExec := func(exe string, args ...string) ([]byte, error, int) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
 		data, err := os.ReadFile("testdata/multiple_groups.json")
 		if err != nil {
 			t.Fatalf("readJson test file: %v", err)
@@ -298,7 +316,7 @@ func TestCollector_Collect_Multiple_Groups(t *testing.T) {
 
 	c := &Collector{
 		resticExecutablePath: "restic",
-		commandExecutor:      fakeExec,
+		commandExecutor:      util.Streaming(fakeExec),
 	}
 
 	reg := prometheus.NewRegistry()
@@ -351,6 +369,14 @@ restic_last_snapshot_files_new{restic_hostname="DPC1",restic_tags="papermc"} 0
 # TYPE restic_last_snapshot_files_unmodified gauge
 restic_last_snapshot_files_unmodified{restic_hostname="DPC1",restic_tags="minebase"} 0
 restic_last_snapshot_files_unmodified{restic_hostname="DPC1",restic_tags="papermc"} 288
+# HELP restic_last_snapshot_info Information about the last snapshot: restic version and user that created it
+# TYPE restic_last_snapshot_info gauge
+restic_last_snapshot_info{program_version="restic 0.18.1",restic_hostname="DPC1",restic_tags="minebase",username="DPC1\\sebls"} 1
+restic_last_snapshot_info{program_version="restic 0.18.1",restic_hostname="DPC1",restic_tags="papermc",username="DPC1\\sebls"} 1
+# HELP restic_last_snapshot_scope_changed Whether the paths or excludes of the last snapshot differ from the ones of the snapshot before
+# TYPE restic_last_snapshot_scope_changed gauge
+restic_last_snapshot_scope_changed{restic_hostname="DPC1",restic_tags="minebase"} 0
+restic_last_snapshot_scope_changed{restic_hostname="DPC1",restic_tags="papermc"} 0
 # HELP restic_last_snapshot_time_seconds Unix timestamp of the last snapshot
 # TYPE restic_last_snapshot_time_seconds gauge
 restic_last_snapshot_time_seconds{restic_hostname="DPC1",restic_tags="minebase"} 1.761495627e+09
@@ -384,3 +410,702 @@ restic_snapshot_exit_code 0
 		t.Fatalf("unexpected metrics output: %v", err)
 	}
 }
+
+func TestCollector_Collect_RecordsStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		err          error
+		exitCode     int
+		wantExitCode int
+		wantReady    bool
+	}{
+		{
+			name:         "success",
+			output:       `[]`,
+			wantExitCode: 0,
+			wantReady:    true,
+		},
+		{
+			name:         "restic error",
+			err:          errors.New("error for unit test"),
+			exitCode:     12,
+			wantExitCode: 12,
+			wantReady:    false,
+		},
+		{
+			name:         "invalid json",
+			output:       `[{`,
+			wantExitCode: 1684,
+			wantReady:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				return []byte(tt.output), tt.err, tt.exitCode
+			}
+
+			repositoryStatus := status.NewRegistry().Add("test")
+			c := &Collector{
+				resticExecutablePath: "restic",
+				commandExecutor:      util.Streaming(fakeExec),
+				status:               repositoryStatus,
+			}
+
+			testutil.CollectAndCount(c)
+
+			got := repositoryStatus.Status()
+			if got.LastRefresh.IsZero() {
+				t.Errorf("last refresh was not recorded")
+			}
+			if got.ExitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d", got.ExitCode, tt.wantExitCode)
+			}
+			if repositoryStatus.Ready() != tt.wantReady {
+				t.Errorf("Ready() = %v, want %v", repositoryStatus.Ready(), tt.wantReady)
+			}
+		})
+	}
+}
+
+func Test_resticErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   string
+	}{
+		{
+			name:   "text",
+			stderr: "Fatal: unable to open config file: stat /srv/restic-repo/config: no such file or directory\nIs there a repository at the following location?\n/srv/restic-repo\n",
+			want:   "Fatal: unable to open config file: stat /srv/restic-repo/config: no such file or directory",
+		},
+		{
+			name:   "exit error message",
+			stderr: "gpg: decryption failed for s3cr3t\n" + `{"message_type":"exit_error","code":1,"message":"Fatal: Resolving password failed: exit status 3\n"}` + "\n",
+			want:   "Fatal: Resolving password failed: exit status 3",
+		},
+		{
+			name:   "no restic error",
+			stderr: "password: s3cr3t\n",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resticErrors([]byte(tt.stderr)); got != tt.want {
+				t.Errorf("resticErrors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollector_Run_ServesCachedResult(t *testing.T) {
+	calls := make(chan struct{}, 10)
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		calls <- struct{}{}
+		return []byte(`[]`), nil, 0
+	}
+
+	repositoryStatus := status.NewRegistry().Add("test")
+	c := &Collector{
+		resticExecutablePath: "restic",
+		commandExecutor:      util.Streaming(fakeExec),
+		status:               repositoryStatus,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, time.Hour)
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatalf("background refresh did not run")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for repositoryStatus.Status().NextRun.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatalf("next run was not scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	expected := `
+# HELP restic_snapshot_exit_code Exit code of the list snapshots command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_snapshot_exit_code gauge
+restic_snapshot_exit_code 0
+# HELP restic_snapshot_count_total Total number of snapshots in the repository
+# TYPE restic_snapshot_count_total gauge
+restic_snapshot_count_total 0
+`
+	for i := 0; i < 3; i++ {
+		if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_snapshot_exit_code", "restic_snapshot_count_total"); err != nil {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+	}
+
+	if len(calls) != 0 {
+		t.Errorf("Collect invoked restic %d times while running in background", len(calls))
+	}
+}
+
+func TestCollector_Restore(t *testing.T) {
+	refreshed := make(chan struct{})
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		<-refreshed
+		return []byte(`[]`), nil, 0
+	}
+
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+	c.Restore([]GroupData{{
+		GroupKey:  GroupKey{Hostname: "SK12", Tags: []string{"kuma"}},
+		Snapshots: []Snapshot{{Time: time.Unix(1760246589, 0), Hostname: "SK12", Tags: []string{"kuma"}}},
+	}}, time.Now().Add(-time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer close(refreshed)
+	go c.Run(ctx, time.Hour)
+
+	// the restored data is served while the first refresh is still running
+	expected := `
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="SK12",restic_tags="kuma"} 1
+# HELP restic_last_snapshot_time_seconds Unix timestamp of the last snapshot
+# TYPE restic_last_snapshot_time_seconds gauge
+restic_last_snapshot_time_seconds{restic_hostname="SK12",restic_tags="kuma"} 1.760246589e+09
+`
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_snapshot_count", "restic_last_snapshot_time_seconds")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected metrics output: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "restic_snapshot_cache_age_seconds" {
+			continue
+		}
+		if age := family.GetMetric()[0].GetGauge().GetValue(); age < 3600 {
+			t.Errorf("cache age = %v, want at least 3600", age)
+		}
+		return
+	}
+	t.Errorf("cache age was not exported")
+}
+
+func TestCollector_Collect_CountsParseErrors(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`[{`), nil, 0
+	}
+
+	parseErrors := prometheus.NewCounter(prometheus.CounterOpts{Name: "parse_errors"})
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, parseErrors)
+
+	testutil.CollectAndCount(c)
+	testutil.CollectAndCount(c)
+
+	if got := testutil.ToFloat64(parseErrors); got != 2 {
+		t.Errorf("parse errors = %v, want 2", got)
+	}
+}
+
+func TestCollector_GroupData(t *testing.T) {
+	output := `[]`
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		if output == "" {
+			return nil, errors.New("error for unit test"), 1
+		}
+		return []byte(output), nil, 0
+	}
+
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+	if _, _, ok := c.GroupData(); ok {
+		t.Fatalf("GroupData() ok before first refresh")
+	}
+
+	output = `[{"group_key":{"hostname":"SK12","tags":["kuma"]},"snapshots":[{"id":"4e66c0cc","time":"2025-10-12T05:23:09.346002024+02:00","hostname":"SK12"}]}]`
+	testutil.CollectAndCount(c)
+
+	output = ""
+	testutil.CollectAndCount(c)
+
+	groupData, refreshedAt, ok := c.GroupData()
+	if !ok || refreshedAt.IsZero() {
+		t.Fatalf("GroupData() ok = %v, refreshedAt = %v", ok, refreshedAt)
+	}
+	if len(groupData) != 1 || groupData[0].Snapshots[0].ID != "4e66c0cc" {
+		t.Errorf("GroupData() did not keep the last successful result: %+v", groupData)
+	}
+}
+
+func TestCollector_LoadIncrementally(t *testing.T) {
+	snapshotJson := func(n int) string {
+		return fmt.Sprintf(`{"time":"2025-10-%02dT05:00:00Z","hostname":"SK12","tags":["kuma"],"summary":{"total_files_processed":%d}}`, n, n)
+	}
+	id := func(n int) string {
+		return strings.Repeat(strconv.Itoa(n), 64)
+	}
+
+	listed := []int{1, 2}
+	var calls []string
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		calls = append(calls, strings.Join(args, " "))
+		switch args[0] {
+		case "snapshots":
+			var snapshots []string
+			for _, n := range listed {
+				snapshots = append(snapshots, strings.Replace(snapshotJson(n), "{", fmt.Sprintf(`{"id":%q,`, id(n)), 1))
+			}
+			return []byte("[" + strings.Join(snapshots, ",") + "]"), nil, 0
+		case "list":
+			var ids []string
+			for _, n := range listed {
+				ids = append(ids, id(n))
+			}
+			return []byte(strings.Join(ids, "\n") + "\n"), nil, 0
+		case "cat":
+			n, _ := strconv.Atoi(args[2][:1])
+			return []byte(snapshotJson(n)), nil, 0
+		}
+		return nil, errors.New("unexpected command"), 1
+	}
+
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+	c.LoadIncrementally()
+
+	tests := []struct {
+		name          string
+		listed        []int
+		wantCalls     []string
+		wantCount     int
+		wantLastFiles int
+	}{
+		{
+			name:          "initial load",
+			listed:        []int{1, 2},
+			wantCalls:     []string{"snapshots --json --no-lock"},
+			wantCount:     2,
+			wantLastFiles: 2,
+		},
+		{
+			name:          "snapshot added",
+			listed:        []int{1, 2, 3},
+			wantCalls:     []string{"list snapshots --no-lock", "cat snapshot " + id(3) + " --no-lock"},
+			wantCount:     3,
+			wantLastFiles: 3,
+		},
+		{
+			name:          "snapshot removed",
+			listed:        []int{2, 3},
+			wantCalls:     []string{"list snapshots --no-lock"},
+			wantCount:     2,
+			wantLastFiles: 3,
+		},
+		{
+			name:          "unchanged",
+			listed:        []int{2, 3},
+			wantCalls:     []string{"list snapshots --no-lock"},
+			wantCount:     2,
+			wantLastFiles: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listed = tt.listed
+			calls = nil
+
+			expected := fmt.Sprintf(`
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="SK12",restic_tags="kuma"} %d
+# HELP restic_last_snapshot_total_files_processed Total number of files processed in the last snapshot
+# TYPE restic_last_snapshot_total_files_processed gauge
+restic_last_snapshot_total_files_processed{restic_hostname="SK12",restic_tags="kuma"} %d
+`, tt.wantCount, tt.wantLastFiles)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_snapshot_count", "restic_last_snapshot_total_files_processed"); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("restic calls = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_Scope(t *testing.T) {
+	snapshotJson := func(day int, paths, excludes string) string {
+		return fmt.Sprintf(`{"time":"2025-10-0%dT12:00:00Z","paths":%s,"excludes":%s,"hostname":"SK12","tags":["kuma"]}`, day, paths, excludes)
+	}
+
+	tests := []struct {
+		name           string
+		previous       string
+		last           string
+		expected       *Scope
+		wantChanged    int
+		wantUnexpected string
+	}{
+		{
+			name:        "unchanged",
+			previous:    snapshotJson(1, `["/srv","/etc"]`, `["*.tmp"]`),
+			last:        snapshotJson(2, `["/etc","/srv"]`, `["*.tmp"]`),
+			wantChanged: 0,
+		},
+		{
+			name:        "exclude added",
+			previous:    snapshotJson(1, `["/etc","/srv"]`, `["*.tmp"]`),
+			last:        snapshotJson(2, `["/etc","/srv"]`, `["*.tmp","/srv/data"]`),
+			wantChanged: 1,
+		},
+		{
+			name:        "matches configured scope",
+			previous:    snapshotJson(1, `["/etc"]`, `null`),
+			last:        snapshotJson(2, `["/etc","/srv"]`, `["*.tmp"]`),
+			expected:    &Scope{Paths: []string{"/srv", "/etc"}, Excludes: []string{"*.tmp"}},
+			wantChanged: 1,
+			wantUnexpected: `
+# HELP restic_last_snapshot_scope_unexpected Whether the paths or excludes of the last snapshot differ from the configured ones, only exported for groups with configured paths
+# TYPE restic_last_snapshot_scope_unexpected gauge
+restic_last_snapshot_scope_unexpected{restic_hostname="SK12",restic_tags="kuma"} 0
+`,
+		},
+		{
+			name:        "path missing from configured scope",
+			previous:    snapshotJson(1, `["/etc","/srv"]`, `null`),
+			last:        snapshotJson(2, `["/etc"]`, `null`),
+			expected:    &Scope{Paths: []string{"/etc", "/srv"}},
+			wantChanged: 1,
+			wantUnexpected: `
+# HELP restic_last_snapshot_scope_unexpected Whether the paths or excludes of the last snapshot differ from the configured ones, only exported for groups with configured paths
+# TYPE restic_last_snapshot_scope_unexpected gauge
+restic_last_snapshot_scope_unexpected{restic_hostname="SK12",restic_tags="kuma"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				return []byte(`[{"group_key":{"hostname":"SK12","tags":["kuma"]},"snapshots":[` + tt.previous + `,` + tt.last + `]}]`), nil, 0
+			}
+			c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+			if tt.expected != nil {
+				c.ExpectScope("SK12", []string{"kuma"}, NewScope(tt.expected.Paths, tt.expected.Excludes))
+			}
+
+			expected := fmt.Sprintf(`
+# HELP restic_last_snapshot_scope_changed Whether the paths or excludes of the last snapshot differ from the ones of the snapshot before
+# TYPE restic_last_snapshot_scope_changed gauge
+restic_last_snapshot_scope_changed{restic_hostname="SK12",restic_tags="kuma"} %d
+`, tt.wantChanged) + tt.wantUnexpected
+			if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_last_snapshot_scope_changed", "restic_last_snapshot_scope_unexpected"); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_ScopeRelabeled(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`[{"group_key":{"hostname":"web1.example.com","tags":["kuma"]},"snapshots":[` +
+			`{"time":"2025-10-01T12:00:00Z","paths":["/mnt/snap-20251001/srv"],"hostname":"web1.example.com","tags":["kuma"]},` +
+			`{"time":"2025-10-02T12:00:00Z","paths":["/mnt/snap-20251002/srv"],"hostname":"web1.example.com","tags":["kuma"]}` +
+			`]}]`), nil, 0
+	}
+	snapshotDir := regexp.MustCompile(`^/mnt/snap-[0-9]+`)
+	relabel := func(group GroupData) (GroupData, bool) {
+		group.GroupKey.Hostname = strings.TrimSuffix(group.GroupKey.Hostname, ".example.com")
+		group.Snapshots = slices.Clone(group.Snapshots)
+		for i := range group.Snapshots {
+			paths := make([]string, 0, len(group.Snapshots[i].Paths))
+			for _, path := range group.Snapshots[i].Paths {
+				paths = append(paths, snapshotDir.ReplaceAllString(path, ""))
+			}
+			group.Snapshots[i].Paths = paths
+		}
+		return group, true
+	}
+
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+	// configured with the hostname and paths restic reports, before the rules are set
+	c.ExpectScope("web1.example.com", []string{"kuma"}, NewScope([]string{"/mnt/snap-20250901/srv"}, nil))
+	c.Relabel(relabel)
+
+	expected := `
+# HELP restic_last_snapshot_scope_changed Whether the paths or excludes of the last snapshot differ from the ones of the snapshot before
+# TYPE restic_last_snapshot_scope_changed gauge
+restic_last_snapshot_scope_changed{restic_hostname="web1",restic_tags="kuma"} 0
+# HELP restic_last_snapshot_scope_unexpected Whether the paths or excludes of the last snapshot differ from the configured ones, only exported for groups with configured paths
+# TYPE restic_last_snapshot_scope_unexpected gauge
+restic_last_snapshot_scope_unexpected{restic_hostname="web1",restic_tags="kuma"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_last_snapshot_scope_changed", "restic_last_snapshot_scope_unexpected"); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+}
+
+func TestCollector_Collect_Anomalous(t *testing.T) {
+	snapshotJson := func(day, bytes, files int) string {
+		return fmt.Sprintf(`{"time":"2025-10-%02dT12:00:00Z","hostname":"SK12","tags":["kuma"],"summary":{"total_bytes_processed":%d,"total_files_processed":%d}}`, day, bytes, files)
+	}
+	thresholds := AnomalyThresholds{Window: 4, MinRatio: 0.5, MaxRatio: 3}
+
+	tests := []struct {
+		name      string
+		snapshots []string
+		want      string
+	}{
+		{
+			name:      "too few earlier snapshots",
+			snapshots: []string{snapshotJson(1, 1000, 10), snapshotJson(2, 1000, 10), snapshotJson(3, 0, 0)},
+			want:      "",
+		},
+		{
+			name: "as usual",
+			snapshots: []string{
+				snapshotJson(1, 1000, 10), snapshotJson(2, 1200, 12), snapshotJson(3, 800, 8), snapshotJson(4, 1100, 11),
+			},
+			want: `
+# HELP restic_last_snapshot_anomalous Whether the bytes or files processed in the last snapshot are outside the configured ratios to the median of the snapshots before
+# TYPE restic_last_snapshot_anomalous gauge
+restic_last_snapshot_anomalous{restic_hostname="SK12",restic_tags="kuma"} 0
+# HELP restic_last_snapshot_bytes_processed_ratio Total bytes processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_bytes_processed_ratio gauge
+restic_last_snapshot_bytes_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 1.1
+# HELP restic_last_snapshot_files_processed_ratio Total files processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_files_processed_ratio gauge
+restic_last_snapshot_files_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 1.1
+`,
+		},
+		{
+			name: "mount missing",
+			snapshots: []string{
+				// the first snapshot is outside the window
+				snapshotJson(1, 1, 1), snapshotJson(2, 1000, 10), snapshotJson(3, 1000, 10), snapshotJson(4, 2000, 20), snapshotJson(5, 2000, 20),
+				snapshotJson(6, 300, 15),
+			},
+			want: `
+# HELP restic_last_snapshot_anomalous Whether the bytes or files processed in the last snapshot are outside the configured ratios to the median of the snapshots before
+# TYPE restic_last_snapshot_anomalous gauge
+restic_last_snapshot_anomalous{restic_hostname="SK12",restic_tags="kuma"} 1
+# HELP restic_last_snapshot_bytes_processed_ratio Total bytes processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_bytes_processed_ratio gauge
+restic_last_snapshot_bytes_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 0.2
+# HELP restic_last_snapshot_files_processed_ratio Total files processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_files_processed_ratio gauge
+restic_last_snapshot_files_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 1
+`,
+		},
+		{
+			name: "unexpectedly large",
+			snapshots: []string{
+				snapshotJson(1, 1000, 10), snapshotJson(2, 1000, 10), snapshotJson(3, 1000, 10), snapshotJson(4, 1000, 40),
+			},
+			want: `
+# HELP restic_last_snapshot_anomalous Whether the bytes or files processed in the last snapshot are outside the configured ratios to the median of the snapshots before
+# TYPE restic_last_snapshot_anomalous gauge
+restic_last_snapshot_anomalous{restic_hostname="SK12",restic_tags="kuma"} 1
+# HELP restic_last_snapshot_bytes_processed_ratio Total bytes processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_bytes_processed_ratio gauge
+restic_last_snapshot_bytes_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 1
+# HELP restic_last_snapshot_files_processed_ratio Total files processed in the last snapshot relative to the median of the snapshots before
+# TYPE restic_last_snapshot_files_processed_ratio gauge
+restic_last_snapshot_files_processed_ratio{restic_hostname="SK12",restic_tags="kuma"} 4
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				return []byte(`[{"group_key":{"hostname":"SK12","tags":["kuma"]},"snapshots":[` + strings.Join(tt.snapshots, ",") + `]}]`), nil, 0
+			}
+			c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+			c.DetectAnomalies(thresholds)
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "restic_last_snapshot_anomalous", "restic_last_snapshot_bytes_processed_ratio", "restic_last_snapshot_files_processed_ratio"); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_Tags(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`[{"group_key":{"hostname":"SK12","tags":["kuma","daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z","hostname":"SK12","tags":["kuma","daily"]}]}]`), nil, 0
+	}
+
+	tests := []struct {
+		name      string
+		sortTags  bool
+		tagSeries bool
+		want      string
+	}{
+		{
+			name: "default",
+			want: `
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="SK12",restic_tags="kuma,daily"} 1
+`,
+		},
+		{
+			name:      "sorted with tag series",
+			sortTags:  true,
+			tagSeries: true,
+			want: `
+# HELP restic_group_tag Tags of the snapshot groups, one series per tag to select groups containing a tag
+# TYPE restic_group_tag gauge
+restic_group_tag{restic_hostname="SK12",restic_tags="daily,kuma",tag="daily"} 1
+restic_group_tag{restic_hostname="SK12",restic_tags="daily,kuma",tag="kuma"} 1
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="SK12",restic_tags="daily,kuma"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+			if tt.sortTags {
+				c.SortTags()
+			}
+			if tt.tagSeries {
+				c.ExportTagSeries()
+			}
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "restic_snapshot_count", "restic_group_tag"); err != nil {
+				t.Errorf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_RelabelAndLimit(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`[` +
+			`{"group_key":{"hostname":"web1.example.com","tags":["daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]},` +
+			`{"group_key":{"hostname":"web1","tags":["daily"]},"snapshots":[{"time":"2025-10-02T12:00:00Z"}]},` +
+			`{"group_key":{"hostname":"3f2a9c1b7e4d","tags":null},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]},` +
+			`{"group_key":{"hostname":"db1","tags":["daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]},` +
+			`{"group_key":{"hostname":"db2","tags":["daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}` +
+			`]`), nil, 0
+	}
+	relabel := func(group GroupData) (GroupData, bool) {
+		if len(group.GroupKey.Hostname) == 12 {
+			return group, false
+		}
+		group.GroupKey.Hostname = strings.TrimSuffix(group.GroupKey.Hostname, ".example.com")
+		return group, true
+	}
+
+	c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+	c.Relabel(relabel)
+	c.LimitGroups(2)
+
+	// the two web1 groups are merged and are over the limit
+	expected := `
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="db1",restic_tags="daily"} 1
+restic_snapshot_count{restic_hostname="db2",restic_tags="daily"} 1
+# HELP restic_last_snapshot_time_seconds Unix timestamp of the last snapshot
+# TYPE restic_last_snapshot_time_seconds gauge
+restic_last_snapshot_time_seconds{restic_hostname="db1",restic_tags="daily"} 1.7593200e+09
+restic_last_snapshot_time_seconds{restic_hostname="db2",restic_tags="daily"} 1.7593200e+09
+# HELP restic_snapshot_dropped_series Number of group series not exported, by reason: relabel for groups dropped by relabel rules, limit for groups over max_groups
+# TYPE restic_snapshot_dropped_series gauge
+restic_snapshot_dropped_series{reason="limit"} 18
+restic_snapshot_dropped_series{reason="relabel"} 18
+# HELP restic_snapshot_groups_overflow Number of groups not exported because the repository had more groups than max_groups
+# TYPE restic_snapshot_groups_overflow gauge
+restic_snapshot_groups_overflow 1
+`
+	metrics := []string{"restic_snapshot_count", "restic_last_snapshot_time_seconds", "restic_snapshot_dropped_series", "restic_snapshot_groups_overflow"}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), metrics...); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+
+	// the overflow of the current refresh is reported, not added up
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), metrics...); err != nil {
+		t.Errorf("unexpected metrics output after the second refresh: %v", err)
+	}
+}
+
+func TestCollector_Collect_LimitGroupsOrder(t *testing.T) {
+	groups := []string{
+		`{"group_key":{"hostname":"web1","tags":["daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}`,
+		`{"group_key":{"hostname":"db1","tags":["weekly"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}`,
+		`{"group_key":{"hostname":"db1","tags":["daily"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}`,
+		`{"group_key":{"hostname":"db2","tags":null},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}`,
+		`{"group_key":{"hostname":"app1","tags":["daily","app"]},"snapshots":[{"time":"2025-10-01T12:00:00Z"}]}`,
+	}
+
+	// the first groups by hostname and tags are exported, whatever order restic lists them in
+	expected := `
+# HELP restic_snapshot_count Number of snapshots
+# TYPE restic_snapshot_count gauge
+restic_snapshot_count{restic_hostname="app1",restic_tags="daily,app"} 1
+restic_snapshot_count{restic_hostname="db1",restic_tags="daily"} 1
+restic_snapshot_count{restic_hostname="db1",restic_tags="weekly"} 1
+`
+	for i := 0; i < 10; i++ {
+		shuffled := slices.Clone(groups)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+			return []byte("[" + strings.Join(shuffled, ",") + "]"), nil, 0
+		}
+
+		c := NewSnapshotCollector("restic", util.Streaming(fakeExec), nil, nil)
+		c.LimitGroups(3)
+		if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "restic_snapshot_count"); err != nil {
+			t.Errorf("unexpected metrics output for %s: %v", shuffled, err)
+		}
+	}
+}
+
+func Test_countSeries(t *testing.T) {
+	snapshots := make([]Snapshot, minAnomalyHistory+1)
+	for i := range snapshots {
+		snapshots[i] = Snapshot{Time: time.Date(2025, 10, 1+i, 12, 0, 0, 0, time.UTC)}
+	}
+	groups := []GroupData{
+		{GroupKey: GroupKey{Hostname: "db1", Tags: []string{"daily", "db"}}, Snapshots: snapshots},
+		{GroupKey: GroupKey{Hostname: "web1", Tags: []string{"daily"}}, Snapshots: snapshots[:1]},
+		{GroupKey: GroupKey{Hostname: "empty"}},
+	}
+
+	// 18 series for every group with snapshots and restic_snapshot_count for the empty one
+	tests := []struct {
+		name string
+		opts collectOptions
+		want int
+	}{
+		{name: "default", want: 37},
+		{name: "tag series", opts: collectOptions{tagSeries: true}, want: 40},
+		{name: "expected scope", opts: collectOptions{expectedScopes: map[string]Scope{GroupKey{Hostname: "db1", Tags: []string{"daily", "db"}}.id(): {}}}, want: 38},
+		{name: "anomalies", opts: collectOptions{anomaly: AnomalyThresholds{Window: 4, MinRatio: 0.5}}, want: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countSeries(groups, tt.opts); got != tt.want {
+				t.Errorf("countSeries() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
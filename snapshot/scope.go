@@ -0,0 +1,87 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+)
+
+// Scope is the set of paths and exclude patterns a backup was created with.
+type Scope struct {
+	Paths    []string
+	Excludes []string
+}
+
+// NewScope returns the scope of paths and excludes, independent of the order they were passed to restic in.
+func NewScope(paths, excludes []string) Scope {
+	return Scope{Paths: sortedCopy(paths), Excludes: sortedCopy(excludes)}
+}
+
+// scopeOf returns the scope snapshot was created with.
+func scopeOf(snapshot Snapshot) Scope {
+	return NewScope(snapshot.Paths, snapshot.Excludes)
+}
+
+// Fingerprint returns a hash of the paths and excludes of the scope.
+func (s Scope) Fingerprint() string {
+	h := sha256.New()
+	for _, path := range s.Paths {
+		h.Write([]byte("path\x00" + path + "\x00"))
+	}
+	for _, exclude := range s.Excludes {
+		h.Write([]byte("exclude\x00" + exclude + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// scopeChanged reports whether the last snapshot of the group was created with another scope than the snapshot before.
+// A group with a single retained snapshot has no previous scope and is reported as unchanged.
+func scopeChanged(group GroupData) bool {
+	if len(group.Snapshots) < 2 {
+		return false
+	}
+
+	snapshots := slices.Clone(group.Snapshots)
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	last, previous := snapshots[len(snapshots)-1], snapshots[len(snapshots)-2]
+	return scopeOf(last).Fingerprint() != scopeOf(previous).Fingerprint()
+}
+
+// expectedScope is a scope passed to Collector.ExpectScope for the group with key.
+type expectedScope struct {
+	key   GroupKey
+	scope Scope
+}
+
+// relabeledScopes returns scopes by GroupKey.id after rewriting their group keys and paths with relabel like the groups,
+// so they are compared to the relabeled snapshots. Scopes of groups dropped by relabel are left out.
+// A new map is returned every time, Collect reads the previous one without holding the lock of the collector.
+func relabeledScopes(scopes []expectedScope, relabel Relabeler) map[string]Scope {
+	relabeled := make(map[string]Scope, len(scopes))
+	for _, s := range scopes {
+		key, scope := s.key, s.scope
+		if relabel != nil {
+			group, ok := relabel(GroupData{
+				GroupKey:  key,
+				Snapshots: []Snapshot{{Hostname: key.Hostname, Tags: key.Tags, Paths: scope.Paths}},
+			})
+			if !ok {
+				continue
+			}
+			key, scope = group.GroupKey, NewScope(group.Snapshots[0].Paths, scope.Excludes)
+		}
+		relabeled[GroupKey{Hostname: key.Hostname, Tags: sortedCopy(key.Tags)}.id()] = scope
+	}
+	return relabeled
+}
+
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
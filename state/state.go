@@ -0,0 +1,188 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"time"
+)
+
+// version is the format version of the state file.
+const version = 1
+
+// File is the content of the state file.
+type File struct {
+	Version      int                   `json:"version"`
+	Repositories map[string]Repository `json:"repositories"`
+}
+
+// Repository holds the last successful results of a repository.
+type Repository struct {
+	Snapshots  *Snapshots  `json:"snapshots,omitempty"`
+	Statistics *Statistics `json:"statistics,omitempty"`
+	Check      *Check      `json:"check,omitempty"`
+}
+
+type Snapshots struct {
+	GroupData   []snapshot.GroupData `json:"group_data"`
+	RefreshedAt time.Time            `json:"refreshed_at"`
+}
+
+type Statistics struct {
+	RawData     statistic.RawDataMetrics `json:"raw_data"`
+	RefreshedAt time.Time                `json:"refreshed_at"`
+}
+
+type Check struct {
+	NumErrors int       `json:"num_errors"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// SnapshotStore provides and restores the snapshot groups of the last successful refresh.
+type SnapshotStore interface {
+	GroupData() ([]snapshot.GroupData, time.Time, bool)
+	Restore(groupData []snapshot.GroupData, refreshedAt time.Time)
+}
+
+// StatisticStore provides and restores the repository statistics of the last successful refresh.
+type StatisticStore interface {
+	Statistics() (statistic.RawDataMetrics, time.Time, bool)
+	Restore(metrics statistic.RawDataMetrics, refreshedAt time.Time)
+}
+
+// CheckStore provides and restores the result of the last finished restic check.
+type CheckStore interface {
+	LastCheck() (numErrors int, checkedAt time.Time, ok bool)
+	Restore(numErrors int, checkedAt time.Time)
+}
+
+// Source is a repository whose results are persisted. Check is nil if the repository isn't checked.
+type Source struct {
+	Name       string
+	Snapshots  SnapshotStore
+	Statistics StatisticStore
+	Check      CheckStore
+}
+
+// Load reads the state file at path. A missing file results in an empty state.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return File{Version: version, Repositories: map[string]Repository{}}, nil
+	}
+	if err != nil {
+		return File{}, err
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return File{}, fmt.Errorf("parse state file: %w", err)
+	}
+	if f.Version != version {
+		return File{}, fmt.Errorf("unsupported state file version %d", f.Version)
+	}
+
+	return f, nil
+}
+
+// Save atomically writes f to the state file at path.
+func Save(path string, f File) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Restore seeds the sources with the results stored in f. Repositories missing in f are left untouched.
+func (f File) Restore(sources []Source) {
+	for _, source := range sources {
+		repository, ok := f.Repositories[source.Name]
+		if !ok {
+			continue
+		}
+
+		if repository.Snapshots != nil {
+			source.Snapshots.Restore(repository.Snapshots.GroupData, repository.Snapshots.RefreshedAt)
+		}
+		if repository.Statistics != nil {
+			source.Statistics.Restore(repository.Statistics.RawData, repository.Statistics.RefreshedAt)
+		}
+		if repository.Check != nil && source.Check != nil {
+			source.Check.Restore(repository.Check.NumErrors, repository.Check.CheckedAt)
+		}
+	}
+}
+
+// Capture returns the state with the last successful results of sources.
+func Capture(sources []Source) File {
+	f := File{Version: version, Repositories: map[string]Repository{}}
+	for _, source := range sources {
+		var repository Repository
+		if groupData, refreshedAt, ok := source.Snapshots.GroupData(); ok {
+			repository.Snapshots = &Snapshots{GroupData: groupData, RefreshedAt: refreshedAt}
+		}
+		if metrics, refreshedAt, ok := source.Statistics.Statistics(); ok {
+			repository.Statistics = &Statistics{RawData: metrics, RefreshedAt: refreshedAt}
+		}
+		if source.Check != nil {
+			if numErrors, checkedAt, ok := source.Check.LastCheck(); ok {
+				repository.Check = &Check{NumErrors: numErrors, CheckedAt: checkedAt}
+			}
+		}
+		f.Repositories[source.Name] = repository
+	}
+	return f
+}
+
+// Run writes the results of sources to the state file at path every interval if they changed until ctx is cancelled.
+// The results are written once more when ctx is cancelled, so nothing is lost on shutdown.
+func Run(ctx context.Context, path string, interval time.Duration, sources []Source) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	saved := Capture(sources)
+	for {
+		select {
+		case <-ctx.Done():
+			if err := Save(path, Capture(sources)); err != nil {
+				slog.Error("Failed to write state file", "path", path, "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		current := Capture(sources)
+		if reflect.DeepEqual(current, saved) {
+			continue
+		}
+
+		if err := Save(path, current); err != nil {
+			slog.Error("Failed to write state file", "path", path, "error", err)
+			continue
+		}
+		saved = current
+	}
+}
@@ -0,0 +1,223 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"restic-stats-exporter/snapshot"
+	"restic-stats-exporter/statistic"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	refreshedAt = time.Date(2025, 10, 12, 6, 0, 0, 0, time.UTC)
+	groupData   = []snapshot.GroupData{{
+		GroupKey: snapshot.GroupKey{Hostname: "SK12", Tags: []string{"kuma"}},
+		Snapshots: []snapshot.Snapshot{{
+			ID:       "4e66c0cc6b3911b64dbf7fba48d9283dcc2d9bb198cdc608c8c25cc6b0c08046",
+			ShortID:  "4e66c0cc",
+			Time:     time.Date(2025, 10, 12, 5, 23, 9, 0, time.UTC),
+			Paths:    []string{"/root/kuma"},
+			Hostname: "SK12",
+			Tags:     []string{"kuma"},
+			Summary:  snapshot.Summary{DataAdded: 1536, TotalFilesProcessed: 12},
+		}},
+	}}
+	rawData = statistic.RawDataMetrics{TotalSize: 181885552, TotalBlobCount: 979, SnapshotCount: 5}
+)
+
+type fakeSnapshotStore struct {
+	mu          sync.Mutex
+	groupData   []snapshot.GroupData
+	refreshedAt time.Time
+	ok          bool
+}
+
+func (f *fakeSnapshotStore) GroupData() ([]snapshot.GroupData, time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.groupData, f.refreshedAt, f.ok
+}
+
+func (f *fakeSnapshotStore) Restore(groupData []snapshot.GroupData, refreshedAt time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.groupData, f.refreshedAt, f.ok = groupData, refreshedAt, true
+}
+
+type fakeStatisticStore struct {
+	metrics     statistic.RawDataMetrics
+	refreshedAt time.Time
+	ok          bool
+}
+
+func (f *fakeStatisticStore) Statistics() (statistic.RawDataMetrics, time.Time, bool) {
+	return f.metrics, f.refreshedAt, f.ok
+}
+
+func (f *fakeStatisticStore) Restore(metrics statistic.RawDataMetrics, refreshedAt time.Time) {
+	f.metrics, f.refreshedAt, f.ok = metrics, refreshedAt, true
+}
+
+type fakeCheckStore struct {
+	numErrors int
+	checkedAt time.Time
+	ok        bool
+}
+
+func (f *fakeCheckStore) LastCheck() (int, time.Time, bool) {
+	return f.numErrors, f.checkedAt, f.ok
+}
+
+func (f *fakeCheckStore) Restore(numErrors int, checkedAt time.Time) {
+	f.numErrors, f.checkedAt, f.ok = numErrors, checkedAt, true
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	want := Capture([]Source{{
+		Name:       "local",
+		Snapshots:  &fakeSnapshotStore{groupData: groupData, refreshedAt: refreshedAt, ok: true},
+		Statistics: &fakeStatisticStore{metrics: rawData, refreshedAt: refreshedAt, ok: true},
+		Check:      &fakeCheckStore{numErrors: 2, checkedAt: refreshedAt, ok: true},
+	}, {
+		Name:       "offsite",
+		Snapshots:  &fakeSnapshotStore{},
+		Statistics: &fakeStatisticStore{},
+	}})
+
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() got = %+v, want %+v", got, want)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files were left behind: %v", entries)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"version":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unsupported := filepath.Join(dir, "unsupported.json")
+	if err := os.WriteFile(unsupported, []byte(`{"version":2}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), wantErr: false},
+		{name: "invalid json", path: invalid, wantErr: true},
+		{name: "unsupported version", path: unsupported, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFile_Restore(t *testing.T) {
+	f := File{Version: version, Repositories: map[string]Repository{
+		"local": {
+			Snapshots:  &Snapshots{GroupData: groupData, RefreshedAt: refreshedAt},
+			Statistics: &Statistics{RawData: rawData, RefreshedAt: refreshedAt},
+			Check:      &Check{NumErrors: 2, CheckedAt: refreshedAt},
+		},
+		"removed": {Snapshots: &Snapshots{GroupData: groupData, RefreshedAt: refreshedAt}},
+	}}
+
+	local := Source{Name: "local", Snapshots: &fakeSnapshotStore{}, Statistics: &fakeStatisticStore{}, Check: &fakeCheckStore{}}
+	added := Source{Name: "added", Snapshots: &fakeSnapshotStore{}, Statistics: &fakeStatisticStore{}}
+	f.Restore([]Source{local, added})
+
+	if got, at, ok := local.Snapshots.GroupData(); !ok || !at.Equal(refreshedAt) || !reflect.DeepEqual(got, groupData) {
+		t.Errorf("restored snapshots = %v, %v, %v", got, at, ok)
+	}
+	if got, at, ok := local.Statistics.Statistics(); !ok || !at.Equal(refreshedAt) || got != rawData {
+		t.Errorf("restored statistics = %v, %v, %v", got, at, ok)
+	}
+	if numErrors, at, ok := local.Check.LastCheck(); !ok || !at.Equal(refreshedAt) || numErrors != 2 {
+		t.Errorf("restored check = %v, %v, %v", numErrors, at, ok)
+	}
+	if _, _, ok := added.Snapshots.GroupData(); ok {
+		t.Errorf("repository missing in the state file was restored")
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	snapshots := &fakeSnapshotStore{}
+	sources := []Source{{Name: "local", Snapshots: snapshots, Statistics: &fakeStatisticStore{}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, path, time.Millisecond, sources)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state file was written without changes")
+	}
+
+	snapshots.Restore(groupData, refreshedAt)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		f, err := Load(path)
+		if err == nil && f.Repositories["local"].Snapshots != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state file was not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRun_SavesOnCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	snapshots := &fakeSnapshotStore{}
+	sources := []Source{{Name: "local", Snapshots: snapshots, Statistics: &fakeStatisticStore{}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, path, time.Hour, sources)
+		close(done)
+	}()
+
+	snapshots.Restore(groupData, refreshedAt)
+	cancel()
+	<-done
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Repositories["local"].Snapshots == nil {
+		t.Error("state file was not written on cancel")
+	}
+}
@@ -0,0 +1,102 @@
+package statistic
+
+import (
+	"context"
+	"restic-stats-exporter/util"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Collector struct {
+	resticExecutablePath string
+	commandExecutor      util.CommandExecutor
+	parseErrors          prometheus.Counter
+	cache                util.Cache[result]
+}
+
+// result holds the outcome of a single restic stats invocation.
+type result struct {
+	exitCode int
+	metrics  RawDataMetrics
+}
+
+func NewStatisticCollector(resticExecutablePath string, commandExecutor util.CommandExecutor, parseErrors prometheus.Counter) *Collector {
+	return &Collector{
+		resticExecutablePath: resticExecutablePath,
+		commandExecutor:      commandExecutor,
+		parseErrors:          parseErrors,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- totalSizeDesc
+	ch <- totalUncompressedSizeDesc
+	ch <- compressionRatioDesc
+	ch <- compressionProgressDesc
+	ch <- compressionSpaceSavingDesc
+	ch <- totalBlobCountDesc
+	ch <- snapshotCountDesc
+	ch <- cacheAgeDesc
+	ch <- statsExitCode
+}
+
+// Run refreshes the repository statistics every interval until ctx is cancelled, Collect serves the last result meanwhile.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	c.cache.Run(ctx, interval, c.refresh, nil)
+}
+
+// refresh reads the raw data statistics of the repository with restic.
+func (c *Collector) refresh(ctx context.Context) (result, bool) {
+	out, err, exitCode := c.commandExecutor(ctx, c.resticExecutablePath, "stats", "--json", "--no-lock", "--mode", "raw-data")
+	if err != nil {
+		return result{exitCode: exitCode}, false
+	}
+
+	metrics, err := readJson(out)
+	if err != nil {
+		if c.parseErrors != nil {
+			c.parseErrors.Inc()
+		}
+		return result{exitCode: util.JSONParseErrorExitCode}, false
+	}
+
+	return result{exitCode: exitCode, metrics: metrics}, true
+}
+
+// Restore seeds the collector with the repository statistics of a previous successful refresh.
+func (c *Collector) Restore(metrics RawDataMetrics, refreshedAt time.Time) {
+	c.cache.Restore(result{metrics: metrics}, refreshedAt)
+}
+
+// Statistics returns the repository statistics of the last successful refresh and the time it started.
+func (c *Collector) Statistics() (metrics RawDataMetrics, refreshedAt time.Time, ok bool) {
+	res, ok := c.cache.LastSuccess()
+	return res.Value.metrics, res.RefreshedAt, ok
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	res, ok := c.cache.Current(context.Background(), c.refresh)
+	if !ok {
+		// the first refresh is still running
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(statsExitCode, prometheus.GaugeValue, float64(res.Value.exitCode))
+	if !res.OK {
+		return
+	}
+
+	if res.Cached {
+		ch <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(res.RefreshedAt).Seconds())
+	}
+
+	metrics := res.Value.metrics
+	ch <- prometheus.MustNewConstMetric(totalSizeDesc, prometheus.GaugeValue, float64(metrics.TotalSize))
+	ch <- prometheus.MustNewConstMetric(totalUncompressedSizeDesc, prometheus.GaugeValue, float64(metrics.TotalUncompressedSize))
+	ch <- prometheus.MustNewConstMetric(compressionRatioDesc, prometheus.GaugeValue, metrics.CompressionRatio)
+	ch <- prometheus.MustNewConstMetric(compressionProgressDesc, prometheus.GaugeValue, float64(metrics.CompressionProgress))
+	ch <- prometheus.MustNewConstMetric(compressionSpaceSavingDesc, prometheus.GaugeValue, metrics.CompressionSpaceSaving)
+	ch <- prometheus.MustNewConstMetric(totalBlobCountDesc, prometheus.GaugeValue, float64(metrics.TotalBlobCount))
+	ch <- prometheus.MustNewConstMetric(snapshotCountDesc, prometheus.GaugeValue, float64(metrics.SnapshotCount))
+}
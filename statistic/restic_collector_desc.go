@@ -0,0 +1,58 @@
+package statistic
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	totalSizeDesc = prometheus.NewDesc(
+		"restic_repository_total_size_bytes",
+		"Total size of the repository (packed)",
+		nil, nil,
+	)
+
+	totalUncompressedSizeDesc = prometheus.NewDesc(
+		"restic_repository_total_uncompressed_size_bytes",
+		"Total size of the repository (unpacked)",
+		nil, nil,
+	)
+
+	compressionRatioDesc = prometheus.NewDesc(
+		"restic_repository_compression_ratio",
+		"Compression ratio of the repository",
+		nil, nil,
+	)
+
+	compressionProgressDesc = prometheus.NewDesc(
+		"restic_repository_compression_progress_percent",
+		"Percentage of the repository data that is compressed",
+		nil, nil,
+	)
+
+	compressionSpaceSavingDesc = prometheus.NewDesc(
+		"restic_repository_compression_space_saving_percent",
+		"Percentage of space saved by compression",
+		nil, nil,
+	)
+
+	totalBlobCountDesc = prometheus.NewDesc(
+		"restic_repository_total_blob_count",
+		"Total number of blobs in the repository",
+		nil, nil,
+	)
+
+	snapshotCountDesc = prometheus.NewDesc(
+		"restic_repository_snapshot_count",
+		"Number of snapshots included in the repository statistics",
+		nil, nil,
+	)
+
+	cacheAgeDesc = prometheus.NewDesc(
+		"restic_stats_cache_age_seconds",
+		"Seconds since the served repository statistics were refreshed, only exported when refreshing in the background",
+		nil, nil,
+	)
+
+	statsExitCode = prometheus.NewDesc("restic_stats_exit_code",
+		"Exit code of the stats command. See restic exit codes, except 1684 for json output parsing errors: "+
+			"https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes",
+		nil, nil)
+)
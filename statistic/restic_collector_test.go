@@ -0,0 +1,161 @@
+package statistic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_Describe(t *testing.T) {
+	c := &Collector{
+		resticExecutablePath: "",
+	}
+
+	expectedDesc := map[string]bool{
+		totalSizeDesc.String():              true,
+		totalUncompressedSizeDesc.String():  true,
+		compressionRatioDesc.String():       true,
+		compressionProgressDesc.String():    true,
+		compressionSpaceSavingDesc.String(): true,
+		totalBlobCountDesc.String():         true,
+		snapshotCountDesc.String():          true,
+		cacheAgeDesc.String():               true,
+		statsExitCode.String():              true,
+	}
+
+	expectedCount := len(expectedDesc)
+
+	ch := make(chan *prometheus.Desc, expectedCount)
+	done := make(chan struct{})
+
+	go func() {
+		c.Describe(ch)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// Describe has finished
+	case <-time.After(200 * time.Millisecond):
+		t.Fatalf("Describe blocked or did not return within timeout — possible extra sends")
+	}
+
+	close(ch)
+
+	got := map[string]bool{}
+	for d := range ch {
+		got[d.String()] = true
+	}
+
+	if len(got) != expectedCount {
+		t.Fatalf("wrong number of descriptors: got %d, want %d", len(got), expectedCount)
+	}
+
+	for want := range expectedDesc {
+		if !got[want] {
+			t.Fatalf("descriptor not sent: %s", want)
+		}
+	}
+}
+
+func TestCollector_Collect_VariousResticExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+	}{
+		{name: "exit code 1", exitCode: 1},
+		{name: "exit code 10", exitCode: 10},
+		{name: "exit code 12", exitCode: 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+				return []byte(``), errors.New("error for unit test"), tt.exitCode
+			}
+
+			c := NewStatisticCollector("restic", fakeExec, nil)
+
+			expected := `
+# HELP restic_stats_exit_code Exit code of the stats command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_stats_exit_code gauge
+restic_stats_exit_code %d
+`
+
+			err := testutil.CollectAndCompare(c, strings.NewReader(fmt.Sprintf(expected, tt.exitCode)))
+			if err != nil {
+				t.Fatalf("unexpected metrics output: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_Collect_InvalidJsonOutput(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		return []byte(`{{`), nil, 0
+	}
+
+	parseErrors := prometheus.NewCounter(prometheus.CounterOpts{Name: "parse_errors"})
+	c := NewStatisticCollector("restic", fakeExec, parseErrors)
+
+	expected := `
+# HELP restic_stats_exit_code Exit code of the stats command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_stats_exit_code gauge
+restic_stats_exit_code 1684
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+	if got := testutil.ToFloat64(parseErrors); got != 1 {
+		t.Errorf("parse errors = %v, want 1", got)
+	}
+}
+
+func TestCollector_Collect(t *testing.T) {
+	fakeExec := func(_ context.Context, exe string, args ...string) ([]byte, error, int) {
+		want := "stats --json --no-lock --mode raw-data"
+		if got := strings.Join(args, " "); got != want {
+			t.Fatalf("unexpected arguments: %s, want %s", got, want)
+		}
+		return []byte("{\"total_size\":181885552,\"total_uncompressed_size\":203507483,\"compression_ratio\":1.1188765724503504,\"compression_progress\":100,\"compression_space_saving\":10.624636834607204,\"total_blob_count\":979,\"snapshots_count\":5}"), nil, 0
+	}
+
+	c := NewStatisticCollector("restic", fakeExec, nil)
+
+	expected := `
+# HELP restic_repository_compression_progress_percent Percentage of the repository data that is compressed
+# TYPE restic_repository_compression_progress_percent gauge
+restic_repository_compression_progress_percent 100
+# HELP restic_repository_compression_ratio Compression ratio of the repository
+# TYPE restic_repository_compression_ratio gauge
+restic_repository_compression_ratio 1.1188765724503504
+# HELP restic_repository_compression_space_saving_percent Percentage of space saved by compression
+# TYPE restic_repository_compression_space_saving_percent gauge
+restic_repository_compression_space_saving_percent 10.624636834607204
+# HELP restic_repository_snapshot_count Number of snapshots included in the repository statistics
+# TYPE restic_repository_snapshot_count gauge
+restic_repository_snapshot_count 5
+# HELP restic_repository_total_blob_count Total number of blobs in the repository
+# TYPE restic_repository_total_blob_count gauge
+restic_repository_total_blob_count 979
+# HELP restic_repository_total_size_bytes Total size of the repository (packed)
+# TYPE restic_repository_total_size_bytes gauge
+restic_repository_total_size_bytes 1.81885552e+08
+# HELP restic_repository_total_uncompressed_size_bytes Total size of the repository (unpacked)
+# TYPE restic_repository_total_uncompressed_size_bytes gauge
+restic_repository_total_uncompressed_size_bytes 2.03507483e+08
+# HELP restic_stats_exit_code Exit code of the stats command. See restic exit codes, except 1684 for json output parsing errors: https://restic.readthedocs.io/en/stable/075_scripting.html#exit-codes
+# TYPE restic_stats_exit_code gauge
+restic_stats_exit_code 0
+`
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatalf("unexpected metrics output: %v", err)
+	}
+}
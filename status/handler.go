@@ -0,0 +1,75 @@
+package status
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>restic statistics exporter status</title></head>
+<body>
+<h1>Repositories</h1>
+<table border="1">
+<tr><th>Name</th><th>Last refresh</th><th>Duration (s)</th><th>Exit code</th><th>Last success</th><th>Next run</th><th>Stderr</th></tr>
+{{- range .}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{if .LastRefresh.IsZero}}never{{else}}{{.LastRefresh.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td>{{printf "%.3f" .LastDuration}}</td>
+<td>{{.ExitCode}}</td>
+<td>{{if .LastSuccess.IsZero}}never{{else}}{{.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td>{{if .NextRun.IsZero}}on next scrape{{else}}{{.NextRun.Format "2006-01-02 15:04:05 MST"}}{{end}}</td>
+<td><pre>{{.Stderr}}</pre></td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// HealthyHandler reports that the process is alive.
+func HealthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("Healthy\n"))
+	})
+}
+
+// ReadyHandler reports whether every repository of the registry was refreshed successfully at least once.
+func ReadyHandler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !registry.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("Not ready\n"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("Ready\n"))
+	})
+}
+
+// Handler serves the state of all repositories as HTML page or, if requested via
+// format=json query parameter or Accept header, as JSON.
+func Handler(registry *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := registry.Statuses()
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(statuses); err != nil {
+				slog.Error("Failed to write status response", "error", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, statuses); err != nil {
+			slog.Error("Failed to write status response", "error", err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package status
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxStderrLength limits how much of the restic stderr output is kept per repository.
+const maxStderrLength = 512
+
+// redacted replaces the secrets in the stderr output.
+const redacted = "<redacted>"
+
+// RepositoryStatus is a point-in-time view of the refresh state of a repository.
+type RepositoryStatus struct {
+	Name         string    `json:"name"`
+	LastRefresh  time.Time `json:"last_refresh"`
+	LastDuration float64   `json:"last_duration_seconds"`
+	LastSuccess  time.Time `json:"last_success"`
+	ExitCode     int       `json:"exit_code"`
+	Stderr       string    `json:"stderr,omitempty"`
+	NextRun      time.Time `json:"next_run"`
+}
+
+// Repository tracks the refresh state of a single restic repository.
+type Repository struct {
+	mu       sync.RWMutex
+	status   RepositoryStatus
+	redactor *strings.Replacer
+}
+
+// Redact replaces secrets in the stderr output recorded from now on.
+func (r *Repository) Redact(secrets ...string) {
+	// longer secrets first, so a secret containing another one is replaced as a whole
+	secrets = slices.SortedFunc(slices.Values(secrets), func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	oldnew := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			oldnew = append(oldnew, secret, redacted)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactor = strings.NewReplacer(oldnew...)
+}
+
+// Record stores the outcome of a refresh that started at start and took duration.
+// A refresh is considered successful if exitCode is 0. Secrets passed to Redact are removed from stderr.
+func (r *Repository) Record(start time.Time, duration time.Duration, exitCode int, stderr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.redactor != nil {
+		stderr = r.redactor.Replace(stderr)
+	}
+	stderr = truncate(stderr, maxStderrLength)
+
+	r.status.LastRefresh = start
+	r.status.LastDuration = duration.Seconds()
+	r.status.ExitCode = exitCode
+	r.status.Stderr = stderr
+	if exitCode == 0 {
+		r.status.LastSuccess = start
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 encoded rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// SetNextRun stores the time of the next scheduled refresh.
+func (r *Repository) SetNextRun(next time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.NextRun = next
+}
+
+// Status returns a copy of the current refresh state.
+func (r *Repository) Status() RepositoryStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+// Ready reports whether at least one refresh of the repository was successful.
+func (r *Repository) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !r.status.LastSuccess.IsZero()
+}
+
+// Registry holds the status of all monitored repositories.
+type Registry struct {
+	mu           sync.RWMutex
+	repositories []*Repository
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add registers a new repository with the given name and returns its status tracker.
+func (r *Registry) Add(name string) *Repository {
+	repository := &Repository{status: RepositoryStatus{Name: name}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repositories = append(r.repositories, repository)
+
+	return repository
+}
+
+// Get returns the status tracker of the repository with the given name or nil if it isn't registered.
+func (r *Registry) Get(name string) *Repository {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, repository := range r.repositories {
+		if repository.Status().Name == name {
+			return repository
+		}
+	}
+
+	return nil
+}
+
+// Statuses returns the current state of all repositories in registration order.
+func (r *Registry) Statuses() []RepositoryStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]RepositoryStatus, 0, len(r.repositories))
+	for _, repository := range r.repositories {
+		statuses = append(statuses, repository.Status())
+	}
+
+	return statuses
+}
+
+// Ready reports whether every registered repository was refreshed successfully at least once.
+func (r *Registry) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, repository := range r.repositories {
+		if !repository.Ready() {
+			return false
+		}
+	}
+
+	return true
+}
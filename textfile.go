@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"restic-stats-exporter/config"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runTextfile collects the metrics of all repositories once and writes them to RSE_TEXTFILE_PATH
+// for the node_exporter textfile collector. The process exits with a non-zero status if the collection failed.
+func runTextfile(cfg config.Config) {
+	path := getEnvWithDefault("RSE_TEXTFILE_PATH", "")
+	if path == "" {
+		slog.Error("Environment variable is not set", "name", "RSE_TEXTFILE_PATH")
+		os.Exit(1)
+	}
+	if !strings.HasSuffix(path, ".prom") {
+		slog.Error("Textfile must have the .prom extension to be read by node_exporter", "path", path)
+		os.Exit(1)
+	}
+
+	registry, _ := newOneShotRegistry(cfg)
+	if err := gatherAndWrite(registry, path); err != nil {
+		slog.Error("Writing textfile failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// gatherAndWrite gathers the metrics of gatherer once and atomically replaces the file at path with them.
+// An error is returned if writing failed or if any restic command exited with a non-zero exit code,
+// in which case the metrics are written nevertheless.
+func gatherAndWrite(gatherer prometheus.Gatherer, path string) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather metrics: %w", err)
+	}
+
+	// WriteToTextfile writes to a temporary file in the same directory and renames it afterwards,
+	// so node_exporter never reads a partially written file.
+	if err := prometheus.WriteToTextfile(path, staticGatherer(families)); err != nil {
+		return fmt.Errorf("write textfile: %w", err)
+	}
+	slog.Info("Wrote metrics to textfile", "path", path)
+
+	if failed := failedCommands(families); len(failed) > 0 {
+		return fmt.Errorf("collection failed: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
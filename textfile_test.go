@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_gatherAndWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode float64
+		wantErr  bool
+	}{
+		{name: "success", exitCode: 0, wantErr: false},
+		{name: "failed collection", exitCode: 1684, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "restic.prom")
+			if err := os.WriteFile(path, []byte("old content\n"), 0o644); err != nil {
+				t.Fatalf("failed to write old textfile: %v", err)
+			}
+
+			exitCode := prometheus.NewGauge(prometheus.GaugeOpts{Name: "restic_snapshot_exit_code"})
+			exitCode.Set(tt.exitCode)
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(exitCode)
+
+			err := gatherAndWrite(registry, path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gatherAndWrite() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read textfile: %v", err)
+			}
+			if !strings.Contains(string(data), "restic_snapshot_exit_code") || strings.Contains(string(data), "old content") {
+				t.Errorf("unexpected textfile content: %s", data)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read directory: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func Test_gatherAndWrite_MissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "restic.prom")
+	if err := gatherAndWrite(prometheus.NewRegistry(), path); err == nil {
+		t.Errorf("gatherAndWrite() expected error")
+	}
+}
@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache serves the result of the last refresh while Run is active, otherwise every Current refreshes.
+// The zero value is an empty cache.
+type Cache[T any] struct {
+	mu          sync.Mutex
+	background  bool
+	cached      *Result[T]
+	lastSuccess *Result[T]
+}
+
+// Result is the value of a refresh that started at RefreshedAt.
+type Result[T any] struct {
+	Value       T
+	OK          bool
+	RefreshedAt time.Time
+	// Cached is set if the result was served from the background refresh.
+	Cached bool
+}
+
+// Refresh computes a new value and reports whether it was successful.
+type Refresh[T any] func(ctx context.Context) (T, bool)
+
+// Run refreshes every interval until ctx is cancelled and passes the time of the next refresh to scheduled, if not nil.
+func (c *Cache[T]) Run(ctx context.Context, interval time.Duration, refresh Refresh[T], scheduled func(next time.Time)) {
+	c.RunFrom(ctx, time.Time{}, interval, refresh, scheduled)
+}
+
+// RunFrom is like Run, but the first refresh starts at first. Until then Current serves the restored result, if any.
+func (c *Cache[T]) RunFrom(ctx context.Context, first time.Time, interval time.Duration, refresh Refresh[T], scheduled func(next time.Time)) {
+	c.mu.Lock()
+	c.background = true
+	c.mu.Unlock()
+
+	if wait := time.Until(first); wait > 0 {
+		if scheduled != nil {
+			scheduled(first)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res := c.doRefresh(ctx, refresh)
+		c.mu.Lock()
+		c.cached = &res
+		c.mu.Unlock()
+
+		if scheduled != nil {
+			scheduled(time.Now().Add(interval))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Current returns the cached result or a new one. ok is false while the first background refresh is running.
+func (c *Cache[T]) Current(ctx context.Context, refresh Refresh[T]) (res Result[T], ok bool) {
+	c.mu.Lock()
+	background, cached := c.background, c.cached
+	c.mu.Unlock()
+
+	if !background {
+		return c.doRefresh(ctx, refresh), true
+	}
+	if cached == nil {
+		return Result[T]{}, false
+	}
+	res = *cached
+	res.Cached = true
+	return res, true
+}
+
+// LastSuccess returns the result of the last successful refresh.
+func (c *Cache[T]) LastSuccess() (res Result[T], ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lastSuccess == nil {
+		return Result[T]{}, false
+	}
+	return *c.lastSuccess, true
+}
+
+// Restore seeds the cache with the value of an earlier successful refresh until the first refresh finished.
+func (c *Cache[T]) Restore(value T, refreshedAt time.Time) {
+	res := Result[T]{Value: value, OK: true, RefreshedAt: refreshedAt}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lastSuccess == nil {
+		c.lastSuccess = &res
+	}
+	if c.cached == nil {
+		c.cached = &res
+	}
+}
+
+func (c *Cache[T]) doRefresh(ctx context.Context, refresh Refresh[T]) Result[T] {
+	start := time.Now()
+	value, ok := refresh(ctx)
+	res := Result[T]{Value: value, OK: ok, RefreshedAt: start}
+
+	if ok {
+		c.mu.Lock()
+		c.lastSuccess = &res
+		c.mu.Unlock()
+	}
+	return res
+}
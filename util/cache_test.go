@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCache_Current_RefreshesWithoutRun(t *testing.T) {
+	var c Cache[int]
+	calls := 0
+	refresh := func(context.Context) (int, bool) {
+		calls++
+		return calls, true
+	}
+
+	for want := 1; want <= 2; want++ {
+		res, ok := c.Current(context.Background(), refresh)
+		if !ok || !res.OK || res.Cached || res.Value != want {
+			t.Errorf("Current() = %+v, %v, want value %d", res, ok, want)
+		}
+	}
+}
+
+func TestCache_Run(t *testing.T) {
+	var c Cache[int]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	refreshed := make(chan struct{})
+	refresh := func(context.Context) (int, bool) {
+		return 42, true
+	}
+	go c.Run(ctx, time.Hour, refresh, func(next time.Time) {
+		close(refreshed)
+	})
+	<-refreshed
+
+	res, ok := c.Current(ctx, func(context.Context) (int, bool) {
+		t.Error("Current() refreshed while Run is active")
+		return 0, false
+	})
+	if !ok || !res.OK || !res.Cached || res.Value != 42 {
+		t.Errorf("Current() = %+v, %v, want cached value 42", res, ok)
+	}
+}
+
+func TestCache_RunFrom(t *testing.T) {
+	var c Cache[int]
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := time.Now().Add(100 * time.Millisecond)
+	scheduled := make(chan time.Time, 2)
+	refreshedAt := make(chan time.Time, 1)
+	refresh := func(context.Context) (int, bool) {
+		refreshedAt <- time.Now()
+		return 42, true
+	}
+	go c.RunFrom(ctx, first, time.Hour, refresh, func(next time.Time) {
+		scheduled <- next
+	})
+
+	if next := <-scheduled; !next.Equal(first) {
+		t.Errorf("first refresh scheduled at %v, want %v", next, first)
+	}
+	if _, ok := c.Current(ctx, func(context.Context) (int, bool) {
+		t.Error("Current() refreshed before the first refresh")
+		return 0, false
+	}); ok {
+		t.Error("Current() ok before the first refresh")
+	}
+	if at := <-refreshedAt; at.Before(first) {
+		t.Errorf("refreshed at %v, before %v", at, first)
+	}
+}
+
+func TestCache_LastSuccess(t *testing.T) {
+	var c Cache[string]
+	if _, ok := c.LastSuccess(); ok {
+		t.Error("LastSuccess() ok before any refresh")
+	}
+
+	c.Current(context.Background(), func(context.Context) (string, bool) { return "first", true })
+	c.Current(context.Background(), func(context.Context) (string, bool) { return "failed", false })
+
+	if res, ok := c.LastSuccess(); !ok || res.Value != "first" {
+		t.Errorf("LastSuccess() = %+v, %v, want first", res, ok)
+	}
+}
+
+func TestCache_Restore(t *testing.T) {
+	var c Cache[string]
+	refreshedAt := time.Date(2025, 10, 12, 5, 0, 0, 0, time.UTC)
+	c.Restore("restored", refreshedAt)
+
+	if res, ok := c.LastSuccess(); !ok || res.Value != "restored" || !res.RefreshedAt.Equal(refreshedAt) {
+		t.Errorf("LastSuccess() = %+v, %v, want restored", res, ok)
+	}
+
+	c.Current(context.Background(), func(context.Context) (string, bool) { return "refreshed", true })
+	if res, _ := c.LastSuccess(); res.Value != "refreshed" {
+		t.Errorf("LastSuccess() = %q after refresh, want refreshed", res.Value)
+	}
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CommandMetrics holds the self-instrumentation metrics of restic invocations, labelled by restic subcommand.
+type CommandMetrics struct {
+	Duration    *prometheus.HistogramVec
+	Invocations *prometheus.CounterVec
+	Failures    *prometheus.CounterVec
+	OutputBytes *prometheus.CounterVec
+	ParseErrors *prometheus.CounterVec
+}
+
+func NewCommandMetrics() *CommandMetrics {
+	return &CommandMetrics{
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "restic_exporter_command_duration_seconds",
+			Help:    "Duration of restic invocations",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 12),
+		}, []string{"subcommand"}),
+		Invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "restic_exporter_command_invocations_total",
+			Help: "Total number of restic invocations",
+		}, []string{"subcommand"}),
+		Failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "restic_exporter_command_failures_total",
+			Help: "Total number of failed restic invocations by reason",
+		}, []string{"subcommand", "reason"}),
+		OutputBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "restic_exporter_command_output_bytes_total",
+			Help: "Total number of bytes read from the standard output of restic",
+		}, []string{"subcommand"}),
+		ParseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "restic_exporter_json_parse_errors_total",
+			Help: "Total number of restic outputs that could not be parsed as JSON",
+		}, []string{"subcommand"}),
+	}
+}
+
+// MustRegister registers all metrics with the registerer and panics on error.
+func (m *CommandMetrics) MustRegister(registerer prometheus.Registerer) {
+	registerer.MustRegister(m.Duration, m.Invocations, m.Failures, m.OutputBytes, m.ParseErrors)
+}
+
+// InstrumentedCommandExecutor wraps executor and records duration, output size and failures
+// of every invocation in metrics. The first argument is used as subcommand label.
+// Outputs that could not be consumed are not counted as failures, the consumer is expected to count them in ParseErrors.
+func InstrumentedCommandExecutor(executor StreamCommandExecutor, metrics *CommandMetrics) StreamCommandExecutor {
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		subcommand := ""
+		if len(arg) > 0 {
+			subcommand = arg[0]
+		}
+
+		var outputBytes int64
+		counting := func(stdout io.Reader) (any, error) {
+			return consume(&countingReader{reader: stdout, count: &outputBytes})
+		}
+
+		start := time.Now()
+		result, err, exitCode := executor(ctx, counting, name, arg...)
+
+		metrics.Duration.WithLabelValues(subcommand).Observe(time.Since(start).Seconds())
+		metrics.Invocations.WithLabelValues(subcommand).Inc()
+		metrics.OutputBytes.WithLabelValues(subcommand).Add(float64(outputBytes))
+		if err != nil && !errors.Is(err, ErrInvalidOutput) {
+			metrics.Failures.WithLabelValues(subcommand, failureReason(err)).Inc()
+		}
+
+		return result, err, exitCode
+	}
+}
+
+// countingReader counts the bytes read from reader.
+type countingReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	*r.count += int64(n)
+	return n, err
+}
+
+// failureReason classifies err into a low cardinality reason label.
+func failureReason(err error) string {
+	if errors.Is(err, ErrTimeout) {
+		return "timeout"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "exit_code"
+	}
+	return "exec"
+}
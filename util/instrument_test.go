@@ -0,0 +1,118 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedCommandExecutor(t *testing.T) {
+	exitErr := exec.Command("false").Run()
+	if exitErr == nil {
+		t.Fatalf("expected false to fail")
+	}
+
+	tests := []struct {
+		name        string
+		output      []byte
+		err         error
+		wantFailure string
+	}{
+		{
+			name:   "success",
+			output: []byte(`[]`),
+		},
+		{
+			name:        "non-zero exit code",
+			err:         exitErr,
+			wantFailure: "exit_code",
+		},
+		{
+			name:        "timeout",
+			err:         fmt.Errorf("%w after 1s: %w", ErrTimeout, exitErr),
+			wantFailure: "timeout",
+		},
+		{
+			name:        "exec error",
+			err:         errors.New("executable file not found in $PATH"),
+			wantFailure: "exec",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := func(_ context.Context, name string, arg ...string) ([]byte, error, int) {
+				return tt.output, tt.err, 0
+			}
+
+			metrics := NewCommandMetrics()
+			instrumented := InstrumentedCommandExecutor(Streaming(executor), metrics).Output()
+			instrumented(t.Context(), "restic", "snapshots", "--json")
+			instrumented(t.Context(), "restic", "snapshots", "--json")
+
+			if got := testutil.ToFloat64(metrics.Invocations.WithLabelValues("snapshots")); got != 2 {
+				t.Errorf("invocations = %v, want 2", got)
+			}
+			if got := testutil.ToFloat64(metrics.OutputBytes.WithLabelValues("snapshots")); got != float64(2*len(tt.output)) {
+				t.Errorf("output bytes = %v, want %v", got, 2*len(tt.output))
+			}
+			if got := testutil.CollectAndCount(metrics.Duration); got != 1 {
+				t.Errorf("duration series = %v, want 1", got)
+			}
+
+			wantFailureSeries := 0
+			if tt.wantFailure != "" {
+				wantFailureSeries = 1
+				if got := testutil.ToFloat64(metrics.Failures.WithLabelValues("snapshots", tt.wantFailure)); got != 2 {
+					t.Errorf("failures = %v, want 2", got)
+				}
+			}
+			if got := testutil.CollectAndCount(metrics.Failures); got != wantFailureSeries {
+				t.Errorf("failure series = %v, want %v", got, wantFailureSeries)
+			}
+		})
+	}
+}
+
+func TestInstrumentedCommandExecutor_InvalidOutput(t *testing.T) {
+	executor := func(_ context.Context, name string, arg ...string) ([]byte, error, int) {
+		return []byte(`[{`), nil, 0
+	}
+	consume := func(stdout io.Reader) (any, error) {
+		var v any
+		return v, json.NewDecoder(stdout).Decode(&v)
+	}
+
+	metrics := NewCommandMetrics()
+	_, err, _ := InstrumentedCommandExecutor(Streaming(executor), metrics)(t.Context(), consume, "restic", "snapshots", "--json")
+
+	if !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("error = %v, want ErrInvalidOutput", err)
+	}
+	if got := testutil.ToFloat64(metrics.OutputBytes.WithLabelValues("snapshots")); got != 2 {
+		t.Errorf("output bytes = %v, want 2", got)
+	}
+	if got := testutil.CollectAndCount(metrics.Failures); got != 0 {
+		t.Errorf("failure series = %v, want 0", got)
+	}
+}
+
+func TestCommandMetrics_Names(t *testing.T) {
+	metrics := NewCommandMetrics()
+	metrics.ParseErrors.WithLabelValues("snapshots").Inc()
+
+	expected := `
+# HELP restic_exporter_json_parse_errors_total Total number of restic outputs that could not be parsed as JSON
+# TYPE restic_exporter_json_parse_errors_total counter
+restic_exporter_json_parse_errors_total{subcommand="snapshots"} 1
+`
+	if err := testutil.CollectAndCompare(metrics.ParseErrors, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metrics output: %v", err)
+	}
+}
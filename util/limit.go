@@ -0,0 +1,123 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// Limiter bounds the number of commands running at the same time.
+// A nil Limiter doesn't limit anything.
+type Limiter struct {
+	slots chan struct{}
+}
+
+// NewLimiter returns a Limiter that allows up to n concurrently running commands.
+// If n is zero or negative, nil is returned and commands are not limited.
+func NewLimiter(n int) *Limiter {
+	if n <= 0 {
+		return nil
+	}
+	return &Limiter{slots: make(chan struct{}, n)}
+}
+
+// acquire waits for a free slot until ctx is cancelled.
+func (l *Limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// LimitedCommandExecutor wraps executor so that a command only starts once a slot of every limiter was acquired.
+// Limiters are acquired in the given order, so a shared global limiter should always be passed last.
+// A command whose ctx is cancelled while waiting fails without running.
+// The queued and running gauges track the number of waiting and running commands.
+func LimitedCommandExecutor(executor StreamCommandExecutor, queued prometheus.Gauge, running prometheus.Gauge, limiters ...*Limiter) StreamCommandExecutor {
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		queued.Inc()
+		acquired := 0
+		var err error
+		for _, l := range limiters {
+			if err = l.acquire(ctx); err != nil {
+				break
+			}
+			acquired++
+		}
+		queued.Dec()
+
+		defer func() {
+			for i := acquired - 1; i >= 0; i-- {
+				limiters[i].release()
+			}
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("wait for a free slot: %w", err), -1
+		}
+
+		running.Inc()
+		defer running.Dec()
+
+		return executor(ctx, consume, name, arg...)
+	}
+}
+
+// TimeoutCommandExecutor wraps executor so that the context of a command is cancelled if it didn't finish within timeout,
+// including the time it waited for a free slot of LimitedCommandExecutor. If timeout is zero or negative, commands are not cancelled.
+func TimeoutCommandExecutor(executor StreamCommandExecutor, timeout time.Duration) StreamCommandExecutor {
+	if timeout <= 0 {
+		return executor
+	}
+
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		result, err, exitCode := executor(ctx, consume, name, arg...)
+		if err != nil && !errors.Is(err, ErrTimeout) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s: %w", ErrTimeout, timeout, err)
+		}
+		return result, err, exitCode
+	}
+}
+
+type commandResult struct {
+	result   any
+	exitCode int
+}
+
+// SingleflightCommandExecutor wraps executor so that concurrent invocations of the same command with the same consumer
+// share a single execution and its consumed result. Consumers are compared by their function, so a closure must not
+// capture state that differs between invocations. The shared execution runs with the ctx of the first invocation.
+func SingleflightCommandExecutor(executor StreamCommandExecutor) StreamCommandExecutor {
+	var group singleflight.Group
+
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		consumer := fmt.Sprintf("%x", reflect.ValueOf(consume).Pointer())
+		key := strings.Join(append([]string{consumer, name}, arg...), "\x00")
+		v, err, _ := group.Do(key, func() (any, error) {
+			result, err, exitCode := executor(ctx, consume, name, arg...)
+			return commandResult{result: result, exitCode: exitCode}, err
+		})
+
+		res := v.(commandResult)
+		return res.result, err, res.exitCode
+	}
+}
@@ -0,0 +1,230 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantNil bool
+	}{
+		{name: "unlimited", n: 0, wantNil: true},
+		{name: "negative", n: -1, wantNil: true},
+		{name: "limited", n: 2, wantNil: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewLimiter(tt.n); (got == nil) != tt.wantNil {
+				t.Errorf("NewLimiter() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestLimitedCommandExecutor(t *testing.T) {
+	const limit = 2
+
+	var current, peak atomic.Int32
+	release := make(chan struct{})
+	executor := func(_ context.Context, name string, arg ...string) ([]byte, error, int) {
+		n := current.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		current.Add(-1)
+		return nil, nil, 0
+	}
+
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queued"})
+	running := prometheus.NewGauge(prometheus.GaugeOpts{Name: "running"})
+	limited := LimitedCommandExecutor(Streaming(executor), queued, running, NewLimiter(limit), NewLimiter(0)).Output()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limited(t.Context(), "restic", "snapshots")
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(running) != limit || testutil.ToFloat64(queued) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("running = %v, queued = %v", testutil.ToFloat64(running), testutil.ToFloat64(queued))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if got := peak.Load(); got != limit {
+		t.Errorf("peak concurrency = %d, want %d", got, limit)
+	}
+	if testutil.ToFloat64(running) != 0 || testutil.ToFloat64(queued) != 0 {
+		t.Errorf("gauges not reset: running = %v, queued = %v", testutil.ToFloat64(running), testutil.ToFloat64(queued))
+	}
+}
+
+func TestSingleflightCommandExecutor(t *testing.T) {
+	var calls, consumed atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	executor := func(_ context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		result, _ := consume(strings.NewReader(`[]`))
+		return result, errors.New("error for unit test"), 3
+	}
+	consume := func(stdout io.Reader) (any, error) {
+		consumed.Add(1)
+		return io.ReadAll(stdout)
+	}
+
+	shared := SingleflightCommandExecutor(executor)
+
+	type result struct {
+		output   string
+		err      error
+		exitCode int
+	}
+	results := make(chan result, 3)
+	run := func() {
+		output, err, exitCode := shared(t.Context(), consume, "restic", "snapshots", "--json")
+		results <- result{string(output.([]byte)), err, exitCode}
+	}
+
+	go run()
+	<-started
+
+	for i := 0; i < 2; i++ {
+		go run()
+	}
+	// give the waiting invocations time to join the running one
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.output != `[]` || r.err == nil || r.exitCode != 3 {
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("executor called %d times, want 1", got)
+	}
+	if got := consumed.Load(); got != 1 {
+		t.Errorf("output consumed %d times, want 1", got)
+	}
+}
+
+func TestLimitedCommandExecutor_Cancelled(t *testing.T) {
+	release := make(chan struct{})
+	executor := func(_ context.Context, name string, arg ...string) ([]byte, error, int) {
+		<-release
+		return nil, nil, 0
+	}
+
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queued"})
+	running := prometheus.NewGauge(prometheus.GaugeOpts{Name: "running"})
+	limited := LimitedCommandExecutor(Streaming(executor), queued, running, NewLimiter(1)).Output()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		limited(t.Context(), "restic", "check")
+	}()
+	for testutil.ToFloat64(running) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err, exitCode := limited(ctx, "restic", "snapshots"); !errors.Is(err, context.DeadlineExceeded) || exitCode != -1 {
+		t.Errorf("waiting command error = %v, exit code = %d, want the context error", err, exitCode)
+	}
+	if testutil.ToFloat64(queued) != 0 {
+		t.Errorf("queued = %v after the waiting command gave up", testutil.ToFloat64(queued))
+	}
+
+	close(release)
+	<-done
+	// the slot of the cancelled command was not taken
+	if _, err, _ := limited(t.Context(), "restic", "snapshots"); err != nil {
+		t.Errorf("command after release failed: %v", err)
+	}
+}
+
+func TestTimeoutCommandExecutor(t *testing.T) {
+	executor := func(ctx context.Context, name string, arg ...string) ([]byte, error, int) {
+		<-ctx.Done()
+		return nil, ctx.Err(), -1
+	}
+
+	_, err, _ := TimeoutCommandExecutor(Streaming(executor), 50*time.Millisecond).Output()(t.Context(), "restic", "snapshots")
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestSingleflightCommandExecutor_Consumers(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	executor := func(_ context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		calls.Add(1)
+		<-release
+		result, err := consume(strings.NewReader(`output`))
+		return result, err, 0
+	}
+	lines := func(stdout io.Reader) (any, error) {
+		return "lines", nil
+	}
+
+	shared := SingleflightCommandExecutor(executor)
+	results := make(chan any, 2)
+	go func() {
+		result, _, _ := shared(t.Context(), readAll, "restic", "snapshots")
+		results <- result
+	}()
+	go func() {
+		result, _, _ := shared(t.Context(), lines, "restic", "snapshots")
+		results <- result
+	}()
+	for calls.Load() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		switch result := (<-results).(type) {
+		case []byte:
+			got[string(result)] = true
+		case string:
+			got[result] = true
+		}
+	}
+	if !got["output"] || !got["lines"] {
+		t.Errorf("results = %v, want the result of every consumer", got)
+	}
+}
@@ -1,14 +1,123 @@
 package util
 
-import "os/exec"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
 
 // CommandExecutor is a function that executes a command and returns the output, error and exit code.
-type CommandExecutor func(name string, arg ...string) ([]byte, error, int)
+// The command is killed if ctx is cancelled.
+type CommandExecutor func(ctx context.Context, name string, arg ...string) ([]byte, error, int)
+
+// Consumer reads the standard output of a command while it is running and returns the parsed result.
+type Consumer func(stdout io.Reader) (any, error)
+
+// StreamCommandExecutor is a function that executes a command, passes its standard output to consume
+// and returns the result of consume, the error and the exit code. The command is killed if ctx is cancelled.
+type StreamCommandExecutor func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int)
+
+// ErrTimeout is wrapped by the error of a command that was killed because it exceeded its timeout.
+var ErrTimeout = errors.New("command timed out")
+
+// ErrInvalidOutput is wrapped by the error of a successful command whose output could not be consumed.
+var ErrInvalidOutput = errors.New("invalid output")
+
+// JSONParseErrorExitCode is reported by the collectors instead of the restic exit code if the restic output could not be parsed.
+const JSONParseErrorExitCode = 1684
 
 // ExecCommandExecutor executes a command with exec.Command and returns the output, error and exit code.
-var ExecCommandExecutor CommandExecutor = func(name string, arg ...string) ([]byte, error, int) {
-	cmd := exec.Command(name, arg...)
-	output, err := cmd.Output()
-	exitCode := cmd.ProcessState.ExitCode()
-	return output, err, exitCode
+var ExecCommandExecutor CommandExecutor = NewEnvCommandExecutor(nil, 0)
+
+// NewEnvCommandExecutor returns a CommandExecutor that runs commands with the given environment.
+// If env is nil, the environment of the current process is inherited.
+// If timeout is positive, commands running longer are killed.
+func NewEnvCommandExecutor(env []string, timeout time.Duration) CommandExecutor {
+	return NewEnvStreamCommandExecutor(env, timeout).Output()
+}
+
+// NewEnvStreamCommandExecutor returns a StreamCommandExecutor that runs commands with the given environment.
+// If env is nil, the environment of the current process is inherited.
+// If timeout is positive, commands running longer are killed.
+func NewEnvStreamCommandExecutor(env []string, timeout time.Duration) StreamCommandExecutor {
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, arg...)
+		cmd.Env = env
+		cmd.Stderr = &stderr
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err, -1
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err, -1
+		}
+
+		result, consumeErr := consume(stdout)
+		// the command blocks if its output isn't read completely
+		_, _ = io.Copy(io.Discard, stdout)
+
+		err = cmd.Wait()
+		exitCode := cmd.ProcessState.ExitCode()
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitErr.Stderr = stderr.Bytes()
+		}
+		switch {
+		case err == nil:
+		case errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0:
+			err = fmt.Errorf("%w after %s: %w", ErrTimeout, timeout, err)
+		case ctx.Err() != nil:
+			err = fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		if err == nil && consumeErr != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalidOutput, consumeErr)
+		}
+
+		return result, err, exitCode
+	}
+}
+
+// Output returns a CommandExecutor that runs commands with executor and returns their complete output.
+func (executor StreamCommandExecutor) Output() CommandExecutor {
+	return func(ctx context.Context, name string, arg ...string) ([]byte, error, int) {
+		output, err, exitCode := executor(ctx, readAll, name, arg...)
+
+		data, _ := output.([]byte)
+		return data, err, exitCode
+	}
+}
+
+// readAll is the consumer of Output. It is a function rather than a closure, so SingleflightCommandExecutor shares its executions.
+func readAll(stdout io.Reader) (any, error) {
+	return io.ReadAll(stdout)
+}
+
+// Streaming returns a StreamCommandExecutor that runs commands with executor and passes their complete output to consume.
+// consume is not called if the command failed.
+func Streaming(executor CommandExecutor) StreamCommandExecutor {
+	return func(ctx context.Context, consume Consumer, name string, arg ...string) (any, error, int) {
+		output, err, exitCode := executor(ctx, name, arg...)
+		if err != nil {
+			return nil, err, exitCode
+		}
+
+		result, err := consume(bytes.NewReader(output))
+		if err != nil {
+			return result, fmt.Errorf("%w: %w", ErrInvalidOutput, err), exitCode
+		}
+		return result, nil, exitCode
+	}
 }
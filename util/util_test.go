@@ -0,0 +1,115 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewEnvCommandExecutor(t *testing.T) {
+	executor := NewEnvCommandExecutor([]string{"RESTIC_REPOSITORY=/srv/restic-repo"}, 0)
+
+	output, err, exitCode := executor(t.Context(), "sh", "-c", `echo "$RESTIC_REPOSITORY"; echo "Fatal: wrong password" >&2; exit 12`)
+
+	if got := strings.TrimSpace(string(output)); got != "/srv/restic-repo" {
+		t.Errorf("output = %q, want %q", got, "/srv/restic-repo")
+	}
+	if exitCode != 12 {
+		t.Errorf("exit code = %d, want 12", exitCode)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("error = %v, want *exec.ExitError", err)
+	}
+	if got := strings.TrimSpace(string(exitErr.Stderr)); got != "Fatal: wrong password" {
+		t.Errorf("stderr = %q, want %q", got, "Fatal: wrong password")
+	}
+}
+
+func TestNewEnvCommandExecutor_Timeout(t *testing.T) {
+	executor := NewEnvCommandExecutor(nil, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err, exitCode := executor(t.Context(), "sleep", "5")
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("command was not killed after the timeout")
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("error = %v, want ErrTimeout", err)
+	}
+	if exitCode != -1 {
+		t.Errorf("exit code = %d, want -1", exitCode)
+	}
+}
+
+func TestNewEnvCommandExecutor_MissingExecutable(t *testing.T) {
+	_, err, exitCode := NewEnvCommandExecutor(nil, time.Second)(t.Context(), "restic-stats-exporter-missing")
+
+	if err == nil || errors.Is(err, ErrTimeout) {
+		t.Errorf("error = %v, want exec error", err)
+	}
+	if exitCode != -1 {
+		t.Errorf("exit code = %d, want -1", exitCode)
+	}
+}
+
+func TestNewEnvStreamCommandExecutor(t *testing.T) {
+	executor := NewEnvStreamCommandExecutor(nil, time.Second)
+
+	var lines []string
+	consume := func(stdout io.Reader) (any, error) {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return len(lines), scanner.Err()
+	}
+
+	result, err, exitCode := executor(t.Context(), consume, "sh", "-c", "echo first; echo second")
+
+	if err != nil || exitCode != 0 {
+		t.Fatalf("error = %v, exit code = %d", err, exitCode)
+	}
+	if result != 2 {
+		t.Errorf("result = %v, want 2", result)
+	}
+}
+
+func TestNewEnvStreamCommandExecutor_InvalidOutput(t *testing.T) {
+	executor := NewEnvStreamCommandExecutor(nil, time.Second)
+
+	// the consumer gives up early, the remaining output must not block the command
+	consume := func(stdout io.Reader) (any, error) {
+		return nil, errors.New("unexpected token")
+	}
+
+	_, err, exitCode := executor(t.Context(), consume, "sh", "-c", "yes | head -c 1000000")
+
+	if !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("error = %v, want ErrInvalidOutput", err)
+	}
+	if exitCode != 0 {
+		t.Errorf("exit code = %d, want 0", exitCode)
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	failing := Streaming(func(_ context.Context, name string, arg ...string) ([]byte, error, int) {
+		return nil, errors.New("exit status 1"), 1
+	})
+	consume := func(stdout io.Reader) (any, error) {
+		t.Errorf("output of a failed command was consumed")
+		return nil, nil
+	}
+
+	if _, err, exitCode := failing(t.Context(), consume, "restic", "snapshots"); err == nil || exitCode != 1 {
+		t.Errorf("error = %v, exit code = %d", err, exitCode)
+	}
+}